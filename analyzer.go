@@ -1,11 +1,9 @@
 package main
 
 import (
-	"bufio"
 	"encoding/base64"
 	"fmt"
 	"github.com/dolthub/go-mysql-server/sql"
-	"github.com/dolthub/go-mysql-server/sql/parse"
 	"github.com/dolthub/go-mysql-server/sql/plan"
 	"github.com/dolthub/go-mysql-server/sql/transform"
 	"golang.org/x/exp/slices"
@@ -19,6 +17,12 @@ type AnalysisOutput struct {
 	patchQueriesOutputPath string
 	queriesOutputPath      string
 	testsOutputPath        string
+	pairingOutputPath      string
+	connectionsOutputPath  string
+	transactionsOutputPath string
+	suspectsOutputPath     string
+	schemaOutputPath       string
+	plansOutputPath        string
 }
 
 type TestRun struct {
@@ -26,6 +30,28 @@ type TestRun struct {
 	FailedTestIds []string
 	Tests         []Test
 	PatchQueries  []PatchQuery
+	PairingIssues []PairingIssue
+	Connections   []Connection
+	Transactions  []Transaction
+	// TransactionAnomalies are transaction and savepoint statements that don't make sense given the statements before them
+	TransactionAnomalies []TransactionAnomaly
+	// SkippedLines are the numbers of the log lines that were too long to analyze
+	SkippedLines []int
+	// TestResults are the tests the test runner reported, in the order they ran
+	TestResults []TestResult
+	// TestFailures are the tracebacks of the tests that failed or errored
+	TestFailures []TestFailure
+	// LogFormats are the names of the formats the log was read in, more than one if the server was restarted with
+	// another dolt release
+	LogFormats []string
+	// LogFormatAssumed is set when a part of the log has no server banner to detect its format from
+	LogFormatAssumed bool
+	// LogLines tells which log each line is in when several logs were read
+	LogLines *LogLines
+	// Schema is the schema that the DDL statements of the log and the DOLT_PATCH results of the report built
+	Schema SchemaHistory
+	// QueryPlans are the analyzed plans of the query shapes that read tables, if plans were asked for
+	QueryPlans []QueryPlan
 }
 
 type PatchQuery struct {
@@ -39,19 +65,43 @@ type Test struct {
 	Failed     bool
 	Queries    []Query
 	TablesUsed []string
+	// ClassId is the id of the test class the test ran in, if the markers tell
+	ClassId string
+	// Result is the result the test runner reported for the test, nil if it's not in the report
+	Result *TestResult
+	// Failure is the traceback of the test if it failed or errored
+	Failure *TestFailure
 }
 
 func (t *Test) String() string {
 	sb := strings.Builder{}
 	sb.WriteString(fmt.Sprintf("Test %s / %s\n", t.Id, PyTestNameFromTestId(t.Id)))
+	if t.ClassId != "" && t.ClassId != t.Id {
+		sb.WriteString(fmt.Sprintf("Class: %s\n", t.ClassId))
+	}
 	if t.Failed {
 		sb.WriteString(fmt.Sprintf("Failed: %t\n", t.Failed))
 	}
+	if t.Result != nil {
+		sb.WriteString(fmt.Sprintf("Result: %s\n", t.Result))
+	}
+	if t.Failure != nil {
+		sb.WriteString(fmt.Sprintf("%s\n", t.Failure))
+	}
+	sb.WriteString(fmt.Sprintf("Query durations: %s\n", NewDurationStats(t.Queries)))
 	sb.WriteString("\n")
 
 	sb.WriteString(fmt.Sprintf("Tables used: %s\n", strings.Join(t.TablesUsed, ", ")))
 	sb.WriteString("Queries: \n")
+	var phase TestPhase
 	for _, query := range t.Queries {
+		if query.TestPhase != phase {
+			phase = query.TestPhase
+			sb.WriteString(fmt.Sprintf("-- phase: %s\n", phase))
+		}
+		if query.ParseError != "" {
+			sb.WriteString(fmt.Sprintf("-- parse error: %s\n", query.ParseError))
+		}
 		sb.WriteString(fmt.Sprintf("%s;\n", query.Text))
 	}
 	sb.WriteString("\n")
@@ -80,30 +130,64 @@ type Pair[T, U any] struct {
 func parseTestRun(settings Settings) (TestRun, error) {
 	result := TestRun{}
 
-	failedTestIds, patchQueries, err := parsePytestReport(settings)
+	failedTestIds, patchQueries, testResults, testFailures, err := parsePytestReport(settings)
 	if err != nil {
 		return result, err
 	}
 	result.FailedTestIds = failedTestIds
 	result.PatchQueries = patchQueries
+	result.TestResults = testResults
+	result.TestFailures = testFailures
+	// a test that several reports have keeps the result of the first, the pytest report before the JUnit reports
+	reportedIds := map[string]bool{}
+	for _, testResult := range result.TestResults {
+		reportedIds[testResult.Id] = true
+	}
+	for _, path := range settings.junitReportPaths {
+		junitResults, junitFailures, err := parseJUnitReport(path)
+		if err != nil {
+			return result, err
+		}
+		// the line numbers of the added results, their failures are on the same line
+		addedLines := map[string]int{}
+		for _, testResult := range junitResults {
+			if reportedIds[testResult.Id] {
+				continue
+			}
+			reportedIds[testResult.Id] = true
+			addedLines[testResult.Id] = testResult.LineNumber
+			testResult.Order = len(result.TestResults) + 1
+			result.TestResults = append(result.TestResults, testResult)
+			if testResult.Status.Failed() && !slices.Contains(result.FailedTestIds, testResult.Id) {
+				result.FailedTestIds = append(result.FailedTestIds, testResult.Id)
+			}
+		}
+		for _, testFailure := range junitFailures {
+			if line, ok := addedLines[testFailure.Id]; ok && line == testFailure.LineNumber {
+				result.TestFailures = append(result.TestFailures, testFailure)
+			}
+		}
+	}
 
-	queries, tests, err := parseQueries(settings, failedTestIds)
+	err = parseQueries(settings, &result)
 	if err != nil {
 		return result, err
 	}
-	result.Queries = queries
-	result.Tests = tests
 
 	return result, nil
 }
 
-func parsePytestReport(settings Settings) (failedTestIds []string, patchQueries []PatchQuery, err error) {
+// parsePytestReport reads the test report of the Django test runner, or of pytest, which is detected from the
+// sections only pytest prints.
+func parsePytestReport(settings Settings) (failedTestIds []string, patchQueries []PatchQuery, testResults []TestResult, testFailures []TestFailure, err error) {
 	patchQueries = make([]PatchQuery, 0)
 	failedTestIds = make([]string, 0)
+	testResults = make([]TestResult, 0)
+	testFailures = make([]TestFailure, 0)
 	if settings.pytestReportPath != "" {
 		input, err := os.Open(settings.pytestReportPath)
 		if err != nil {
-			return failedTestIds, patchQueries, err
+			return failedTestIds, patchQueries, testResults, testFailures, err
 		}
 		defer input.Close()
 
@@ -111,15 +195,27 @@ func parsePytestReport(settings Settings) (failedTestIds []string, patchQueries
 		nextLineHasPatchQuery := false
 		patchQueryTargetTable := ""
 		separatorSeen := false
-		scanner := bufio.NewScanner(input)
-		for scanner.Scan() {
-			line := scanner.Text()
+		resultParser := newTestResultParser()
+		failureParser := newTestFailureParser()
+		pytestParser := newPytestResultParser()
+		reader := NewLineReader(input, settings.maxLineLength)
+		for reader.Next() {
+			line := reader.Text()
 			lineNumber++
+			if reader.TooLong() {
+				settings.logger.Logf("Line %d of the pytest report, skipping line longer than %d bytes", lineNumber, settings.maxLineLength)
+				nextLineHasPatchQuery = false
+				continue
+			}
 			if line == pytestReportSeparator {
 				separatorSeen = true
 			}
+			resultParser.line(lineNumber, line)
+			pytestParser.line(lineNumber, line)
 
 			if separatorSeen {
+				failureParser.line(lineNumber, line)
+
 				// check if the test failed
 				failedTestParts := RegexSplit(line, pyTestFailedRegex)
 				if len(failedTestParts) == 0 {
@@ -128,7 +224,7 @@ func parsePytestReport(settings Settings) (failedTestIds []string, patchQueries
 				}
 
 				if len(failedTestParts) == 2 {
-					testId := fmt.Sprintf("%s.%s", failedTestParts[1], failedTestParts[0])
+					testId := testResultId(failedTestParts[0], failedTestParts[1])
 					failedTestIds = append(failedTestIds, testId)
 				}
 			} else {
@@ -165,151 +261,241 @@ func parsePytestReport(settings Settings) (failedTestIds []string, patchQueries
 				}
 			}
 		}
+		if err := reader.Err(); err != nil {
+			return failedTestIds, patchQueries, testResults, testFailures, fmt.Errorf("reading %s: %w", settings.pytestReportPath, err)
+		}
+
+		// the failures are listed after the results, but a run that was interrupted only has the results
+		testResults = resultParser.end()
+		testFailures = failureParser.end()
+		if pytestParser.detected {
+			testResults, testFailures = pytestParser.end()
+		}
+		for _, result := range testResults {
+			if result.Status.Failed() && !slices.Contains(failedTestIds, result.Id) {
+				failedTestIds = append(failedTestIds, result.Id)
+			}
+		}
 	}
-	return failedTestIds, patchQueries, nil
+	return failedTestIds, patchQueries, testResults, testFailures, nil
 }
 
-func parseQueries(settings Settings, failedTestIds []string) (QueryCollection, []Test, error) {
+// parseQueries parses the dolt log and fills in the queries, tests and pairing issues of the test run.
+func parseQueries(settings Settings, testRun *TestRun) error {
 	queryCollection := NewQueryCollection()
-	tests := []Test{}
-	var currentTest *Test
-	ctx := sql.NewEmptyContext()
+	// tests run in parallel interleave their queries, so queries are added to their test by id
+	tests := []*Test{}
+	testsById := map[string]*Test{}
 	logger := settings.logger
+	failedTestIds := testRun.FailedTestIds
+	testFailuresById := map[string]*TestFailure{}
+	for i := range testRun.TestFailures {
+		if _, ok := testFailuresById[testRun.TestFailures[i].Id]; !ok {
+			testFailuresById[testRun.TestFailures[i].Id] = &testRun.TestFailures[i]
+		}
+	}
+	pairer := newQueryPairer()
+	connections := newConnectionTracker()
+	transactions := newTransactionTracker()
+	schema := newSchemaTracker(testRun.PatchQueries)
 
-	input, err := os.Open(settings.doltLogFilePath)
+	format, err := logFormatForName(settings.logFormat)
+	if err != nil {
+		return err
+	}
+	markers, err := loadTestMarkers(settings.testMarkers)
+	if err != nil {
+		return err
+	}
+	testTracker := newTestTracker(markers, settings.unmarkedPolicy)
+	input, err := openLogInput(settings.doltLogPaths)
 	if err != nil {
-		return queryCollection, tests, err
+		return err
 	}
 	defer input.Close()
+	testRun.LogLines = input.lines
+
+	pipeline := parseLogLines(input, settings.maxLineLength, settings.parseWorkers, format)
+	for result := range pipeline.results {
+		line := <-result
+		lineNumber := line.LineNumber
+		if line.TooLong {
+			logger.Logf("Line %s, skipping line longer than %d bytes", testRun.LogLines.Location(lineNumber), settings.maxLineLength)
+			testRun.SkippedLines = append(testRun.SkippedLines, lineNumber)
+			continue
+		}
+		record := line.Record
 
-	testId := ""
-	lineNumber := 0
-	scanner := bufio.NewScanner(input)
-	for scanner.Scan() {
-		line := scanner.Text()
-		lineNumber++
-
-		finishedParse := RegexSplit(line, finishedQueryRegex)
-		errorParse := RegexSplit(line, errorQueryRegex)
-
-		var query string
 		var queryError string
 
-		switch {
-		case finishedParse != nil:
-			query = finishedParse[0]
-		case errorParse != nil:
-			queryError = errorParse[0]
-			query = errorParse[1]
+		switch record.Kind {
+		case RecordQueryStarted:
+			pairer.start(record, line.Query, testTracker.scope(record.ConnectionId).TestId)
+			continue
+		case RecordConnectionOpened:
+			connections.opened(record)
+			continue
+		case RecordConnectionClosed:
+			pairer.connectionClosed(record)
+			connections.closed(record)
+			testTracker.connectionClosed(record.ConnectionId)
+			transactions.connectionClosed(record)
+			continue
+		case RecordQueryFinished:
+		case RecordQueryError:
+			queryError = record.Error()
 		default:
 			continue
 		}
 
-		if query == "" {
+		if record.Query() == "" {
 			continue
 		}
-
-		// recent dolt supports base64 encoding of queries, try to decode
-		queryBytes, err := base64.StdEncoding.DecodeString(query)
-		if err == nil {
-			query = string(queryBytes)
+		query := line.Query
+		var startRecord LogRecord
+		started := false
+		if !line.Unpaired {
+			startRecord, started = pairer.finish(record, query, testTracker.scope(record.ConnectionId).TestId)
 		}
 
-		testStartingParse := RegexSplit(query, testStartingRegex)
-		testFinishedParse := RegexSplit(query, testFinishedRegex)
-		switch {
-		case testStartingParse != nil:
-			testId = testStartingParse[0]
-			//// this was a "notification" query, no need to analyze it
-			//continue
-		case testFinishedParse != nil:
-			finishedTestId := testFinishedParse[0]
-			if finishedTestId != testId {
-				logger.Logf("Line %d, test id mismatch: %s (this test) != %s (last started test)",
-					lineNumber, finishedTestId, testId)
-			}
-			testId = ""
-			//// this was a "notification" query, no need to analyze it
-			//continue
+		scope, warnings := testTracker.process(record.ConnectionId, query)
+		for _, warning := range warnings {
+			logger.Logf("Line %s, %s", testRun.LogLines.Location(lineNumber), warning)
 		}
-
-		if settings.hideNonTestQueries && testId == "" {
-			continue
+		testId := scope.TestId
+
+		// queries the parser can't handle are kept, without a tree
+		node := line.Node
+		var parseError string
+		if line.ParseError != nil {
+			logger.Logf("Line %s, error parsing query '%s': %s", testRun.LogLines.Location(lineNumber), query, line.ParseError)
+			parseError = line.ParseError.Error()
 		}
+		// a query that can't be normalized is still reported, it just has no normalized tree
+		if line.NormalizeError != nil {
+			logger.Logf("Line %s, error normalizing query '%s': %s", testRun.LogLines.Location(lineNumber), query, line.NormalizeError)
+		}
+
+		// hidden queries still change the session and transaction state of their connection
+		session, nextSession := connections.replaySession(record, node, queryError)
+		transactionId, savepointDepth := transactions.process(record, node, queryError, session, nextSession)
+		schema.process(lineNumber, query, node, session.Database, queryError)
 
 		var testFailed bool
 		var pyTestName string
+		var testFailure *TestFailure
 		if testId != "" {
 			pyTestName = PyTestNameFromTestId(testId)
 			testFailed = slices.Contains(failedTestIds, testId)
+			testFailure = testFailuresById[testId]
 		}
 
-		var node sql.Node
-		parsedNode, err := parse.Parse(ctx, query)
-		if err != nil {
-			logger.Logf("Line %d, error parsing query '%s': %s", lineNumber, query, err)
-			continue
-		} else {
-			node = parsedNode
+		queryObj := Query{
+			TestId:        testId,
+			TestClassId:   scope.ClassId,
+			TestPhase:     scope.Phase,
+			TestFailure:   testFailure,
+			PyTestName:    pyTestName,
+			TestFailed:    testFailed,
+			Text:          query,
+			Node:          node,
+			LineNumber:    lineNumber,
+			Error:         queryError,
+			ParseError:    parseError,
+			NodeDebug:     line.NodeDebug,
+			Fingerprint:   line.Fingerprint,
+			Timestamp:     record.Timestamp,
+			Level:         record.Level,
+			ConnectionId:  record.ConnectionId,
+			ConnectTime:   record.ConnectTime(),
+			ConnectionDb:  record.ConnectionDb(),
+			Duration:      record.Duration,
+			Session:       session,
+			TransactionId: transactionId,
 		}
+		if started {
+			queryObj.StartLineNumber = startRecord.LineNumber
+			queryObj.StartTimestamp = startRecord.Timestamp
+		}
+		queryObj.NormalizedTree = line.Normalized
 
-		//cleanNode, err := DropExtraneousData(node)
-		//if err != nil {
-		//	logger.Logf("Line %d, error cleaning query '%s': %s", lineNumber, query, err)
-		//	continue
-		//} else {
-		//	node = cleanNode
-		//}
-
-		nodeDebugString := sql.DebugString(node)
-
-		queryObj := Query{
-			TestId:     testId,
-			PyTestName: pyTestName,
-			TestFailed: testFailed,
-			Text:       query,
-			Node:       node,
-			LineNumber: lineNumber,
-			Error:      queryError,
-			NodeDebug:  nodeDebugString,
+		// hidden queries still count for their connection and are statements of their transaction
+		connections.addQuery(queryObj)
+		transactions.addQuery(queryObj, savepointDepth)
+		if settings.hideNonTestQueries && testId == "" {
+			continue
 		}
+
 		queryCollection.Add(queryObj)
 
 		if testId != "" {
 			tablesUsed := getTablesUsed(node)
 
-			if currentTest == nil || currentTest.Id != testId {
-				if currentTest != nil {
-					tests = append(tests, *currentTest)
-					currentTest = nil
+			test, ok := testsById[testId]
+			if !ok {
+				test = &Test{
+					Id:      testId,
+					ClassId: scope.ClassId,
+					Failed:  testFailed,
+					Failure: testFailure,
 				}
-				currentTest = &Test{
-					Id:         testId,
-					Failed:     testFailed,
-					Queries:    []Query{queryObj},
-					TablesUsed: tablesUsed,
-				}
-			} else {
-				currentTest.Queries = append(currentTest.Queries, queryObj)
-				for _, table := range tablesUsed {
-					if !slices.Contains(currentTest.TablesUsed, table) {
-						currentTest.TablesUsed = append(currentTest.TablesUsed, table)
-					}
+				tests = append(tests, test)
+				testsById[testId] = test
+			}
+			test.Queries = append(test.Queries, queryObj)
+			for _, table := range tablesUsed {
+				if !slices.Contains(test.TablesUsed, table) {
+					test.TablesUsed = append(test.TablesUsed, table)
 				}
 			}
 		}
 	}
 
-	if currentTest != nil {
-		tests = append(tests, *currentTest)
-		currentTest = nil
+	if pipeline.err != nil {
+		return fmt.Errorf("reading %s: %w", strings.Join(settings.doltLogPaths, ", "), pipeline.err)
 	}
 
-	return queryCollection, tests, nil
+	for _, format := range pipeline.formats {
+		if _, assumed := format.(assumedLogFormat); assumed {
+			testRun.LogFormatAssumed = true
+		}
+		// a banner of the assumed format only confirms it
+		if len(testRun.LogFormats) == 0 || testRun.LogFormats[len(testRun.LogFormats)-1] != format.Name() {
+			testRun.LogFormats = append(testRun.LogFormats, format.Name())
+		}
+	}
+	testRun.Queries = queryCollection
+	// a test keeps its first result, the pytest report's results come before the JUnit reports'
+	testResultsById := map[string]*TestResult{}
+	for i := range testRun.TestResults {
+		if _, ok := testResultsById[testRun.TestResults[i].Id]; !ok {
+			testResultsById[testRun.TestResults[i].Id] = &testRun.TestResults[i]
+		}
+	}
+	testRun.Tests = make([]Test, 0, len(tests))
+	for _, test := range tests {
+		test.Result = testResultsById[test.Id]
+		testRun.Tests = append(testRun.Tests, *test)
+	}
+	testRun.PairingIssues = pairer.end()
+	testRun.Connections = connections.end()
+	testRun.Transactions, testRun.TransactionAnomalies = transactions.end()
+	var planner *queryPlanner
+	if settings.resolvePlans {
+		planner = newQueryPlanner(queryCollection)
+	}
+	testRun.Schema = schema.end(planner)
+	if planner != nil {
+		testRun.QueryPlans = planner.end()
+	}
+	return nil
 }
 
 func getTablesUsed(node sql.Node) []string {
 	tables := []string{}
+	if node == nil {
+		return tables
+	}
 	transform.Inspect(node, func(node sql.Node) bool {
 		var tableName string
 		switch node := node.(type) {
@@ -376,7 +562,7 @@ func AnalyzeTestRun(settings Settings) (AnalysisOutput, error) {
 		flatQueriesLogger := NewFileLogger(flatQueriesOutput)
 
 		for _, query := range queryCollection.All {
-			queriesLogger.Logf(query.String(settings.logQueryText))
+			queriesLogger.Log(query.String(settings.logQueryText, testRun.LogLines))
 			queriesLogger.Log(analysisReportSeparator)
 			flatQueriesLogger.Logf("%s;\n", query.Text)
 		}
@@ -415,12 +601,120 @@ func AnalyzeTestRun(settings Settings) (AnalysisOutput, error) {
 		defer testsOutput.Close()
 		testsLogger := NewFileLogger(testsOutput)
 		for _, test := range testRun.Tests {
-			testsLogger.Logf(test.String())
+			testsLogger.Log(test.String())
 			testsLogger.Log(analysisReportSeparator)
 		}
 		result.testsOutputPath = testsOutputPath
 	}
 
+	// write queries that couldn't be paired with their start or finish to a file
+	if len(testRun.PairingIssues) > 0 {
+		pairingOutputPath := settings.GetOutputFilePath(".pairing_issues")
+		pairingOutput, err := os.Create(pairingOutputPath)
+		if err != nil {
+			return result, err
+		}
+		defer pairingOutput.Close()
+		pairingLogger := NewFileLogger(pairingOutput)
+		for _, issue := range testRun.PairingIssues {
+			pairingLogger.Log(issue.String(testRun.LogLines))
+			pairingLogger.Log(analysisReportSeparator)
+		}
+		result.pairingOutputPath = pairingOutputPath
+	}
+
+	// write connections to a file
+	if len(testRun.Connections) > 0 {
+		connectionsOutputPath := settings.GetOutputFilePath(".connections")
+		connectionsOutput, err := os.Create(connectionsOutputPath)
+		if err != nil {
+			return result, err
+		}
+		defer connectionsOutput.Close()
+		connectionsLogger := NewFileLogger(connectionsOutput)
+		for _, connection := range testRun.Connections {
+			connectionsLogger.Log(connection.String(testRun.LogLines))
+			connectionsLogger.Log(analysisReportSeparator)
+		}
+		result.connectionsOutputPath = connectionsOutputPath
+	}
+
+	// write transactions to a file
+	if len(testRun.Transactions) > 0 || len(testRun.TransactionAnomalies) > 0 {
+		transactionsOutputPath := settings.GetOutputFilePath(".transactions")
+		transactionsOutput, err := os.Create(transactionsOutputPath)
+		if err != nil {
+			return result, err
+		}
+		defer transactionsOutput.Close()
+		transactionsLogger := NewFileLogger(transactionsOutput)
+		transactionsLogger.Logf("Anomalies: %d\n", len(testRun.TransactionAnomalies))
+		for _, anomaly := range testRun.TransactionAnomalies {
+			transactionsLogger.Logf("%s\n", anomaly.String(testRun.LogLines))
+		}
+		transactionsLogger.Log(analysisReportSeparator)
+		for _, transaction := range testRun.Transactions {
+			transactionsLogger.Log(transaction.String(testRun.LogLines))
+			transactionsLogger.Log(analysisReportSeparator)
+		}
+		result.transactionsOutputPath = transactionsOutputPath
+	}
+
+	// write the query shapes, tables and constructs that failing tests have more often than passing tests to a file
+	suspects := rankSuspects(testRun.Tests)
+	if len(suspects) > 0 {
+		suspectsOutputPath := settings.GetOutputFilePath(".suspects")
+		suspectsOutput, err := os.Create(suspectsOutputPath)
+		if err != nil {
+			return result, err
+		}
+		defer suspectsOutput.Close()
+		suspectsLogger := NewFileLogger(suspectsOutput)
+		suspectsLogger.Logf("Failing tests: %d, passing tests: %d\n", Count(testRun.Tests, func(test Test) bool {
+			return test.Failed && test.Id != test.ClassId
+		}), Count(testRun.Tests, func(test Test) bool {
+			return !test.Failed && test.Id != test.ClassId
+		}))
+		suspectsLogger.Log(analysisReportSeparator)
+		for index, suspect := range suspects {
+			if index == settings.suspectCount {
+				break
+			}
+			suspectsLogger.Log(suspect.String())
+			suspectsLogger.Log(analysisReportSeparator)
+		}
+		result.suspectsOutputPath = suspectsOutputPath
+	}
+
+	// write the schema that the DDL statements and the DOLT_PATCH results built to a file
+	finalTables := testRun.Schema.Final()
+	if len(finalTables) > 0 {
+		schemaOutputPath := settings.GetOutputFilePath(".schema")
+		schemaOutput, err := os.Create(schemaOutputPath)
+		if err != nil {
+			return result, err
+		}
+		defer schemaOutput.Close()
+		NewFileLogger(schemaOutput).Log(testRun.Schema.Dump())
+		result.schemaOutputPath = schemaOutputPath
+	}
+
+	// write the plans of the query shapes to a file, the ones that took the longest first
+	if len(testRun.QueryPlans) > 0 {
+		plansOutputPath := settings.GetOutputFilePath(".plans")
+		plansOutput, err := os.Create(plansOutputPath)
+		if err != nil {
+			return result, err
+		}
+		defer plansOutput.Close()
+		plansLogger := NewFileLogger(plansOutput)
+		for _, queryPlan := range testRun.QueryPlans {
+			plansLogger.Log(queryPlan.String(testRun.LogLines))
+			plansLogger.Log(analysisReportSeparator)
+		}
+		result.plansOutputPath = plansOutputPath
+	}
+
 	// write analysis to a file
 	analysisOutputPath := settings.GetOutputFilePath(".analysis")
 	analysisOutput, err := os.Create(analysisOutputPath)
@@ -430,23 +724,136 @@ func AnalyzeTestRun(settings Settings) (AnalysisOutput, error) {
 	defer analysisOutput.Close()
 	analysisLogger := NewProxyLogger(NewFileLogger(analysisOutput), settings.logger)
 
+	analysisLogger.Logf("Log format: %s\n", strings.Join(testRun.LogFormats, ", "))
+	if testRun.LogFormatAssumed {
+		analysisLogger.Logf("Warning: the log has no server banner to detect its format from, its queries were read as %s. "+
+			"Use -log-format to pick the format\n", undetectedLogFormat.Name())
+	}
 	analysisLogger.Logf("Total queries: %d\n", len(queryCollection.All))
 	analysisLogger.Logf("Number of tests: %d\n", len(queryCollection.ByTestId))
 	analysisLogger.Logf("Number of test queries: %d\n", len(queryCollection.TestQueries))
+	analysisLogger.Logf("Number of connections: %d\n", len(testRun.Connections))
+	analysisLogger.Logf("Connections never closed: %d\n", Count(testRun.Connections, func(c Connection) bool {
+		return !c.Closed()
+	}))
+	analysisLogger.Logf("Number of transactions: %d\n", len(testRun.Transactions))
+	analysisLogger.Logf("Transaction anomalies: %d\n", len(testRun.TransactionAnomalies))
+	analysisLogger.Logf("Queries started but never finished: %d\n",
+		Count(testRun.PairingIssues, isPairingIssueKind(QueryNeverFinished)))
+	analysisLogger.Logf("Queries finished without start: %d\n",
+		Count(testRun.PairingIssues, isPairingIssueKind(QueryFinishedWithoutStart)))
+	analysisLogger.Logf("Queries running when connection closed: %d\n",
+		Count(testRun.PairingIssues, isPairingIssueKind(QueryRunningAtConnectionClose)))
+	analysisLogger.Logf("Parse failures: %d\n", len(queryCollection.ParseFailures))
+	analysisLogger.Logf("Lines too long to analyze: %d\n", len(testRun.SkippedLines))
+	unmarkedTests, unreportedTests := compareTestResults(testRun.TestResults, testRun.Tests)
+	if len(testRun.TestResults) > 0 {
+		analysisLogger.Logf("Test results: %d (%s)\n", len(testRun.TestResults), countTestStatuses(testRun.TestResults))
+		analysisLogger.Logf("Tests without marker queries: %d\n", len(unmarkedTests))
+		analysisLogger.Logf("Marked tests missing from the report: %d\n", len(unreportedTests))
+	}
+	failedSchemaChanges := testRun.Schema.FailedChanges()
+	if len(testRun.Schema.Changes) > 0 {
+		analysisLogger.Logf("Schema changes: %d (%d couldn't be applied)\n", len(testRun.Schema.Changes), len(failedSchemaChanges))
+		analysisLogger.Logf("Tables in the final schema: %d\n", len(finalTables))
+	}
+	if len(testRun.QueryPlans) > 0 {
+		analysisLogger.Logf("Query plans: %d (%d with full table scans, %d with nested loop joins, %d couldn't be analyzed)\n",
+			len(testRun.QueryPlans),
+			Count(testRun.QueryPlans, func(p QueryPlan) bool { return len(p.TableScans) > 0 }),
+			Count(testRun.QueryPlans, func(p QueryPlan) bool { return p.NestedLoopJoins > 0 }),
+			Count(testRun.QueryPlans, func(p QueryPlan) bool { return p.Error != "" }))
+	}
+	if len(suspects) > 0 {
+		analysisLogger.Logf("Top suspect: %s %s (Ochiai %.3f)\n", suspects[0].Kind, suspects[0].Key, suspects[0].Ochiai)
+	}
+	analysisLogger.Logf("Query durations: %s\n", NewDurationStats(queryCollection.All))
 	analysisLogger.Log(analysisReportSeparator)
 	result.analysisOutputPath = analysisOutputPath
 
+	// tests that ran without markers, or markers without a test, are gaps in the test instrumentation
+	if len(unmarkedTests) > 0 || len(unreportedTests) > 0 {
+		analysisLogger.Logf("Instrumentation gaps:\n\n")
+		if len(unmarkedTests) > 0 {
+			analysisLogger.Logf("Tests without marker queries:\n")
+			for _, testResult := range unmarkedTests {
+				analysisLogger.Logf("Report line %d: %s ... %s\n", testResult.LineNumber, testResult.Id, testResult.Status)
+			}
+			analysisLogger.Log("\n")
+		}
+		if len(unreportedTests) > 0 {
+			analysisLogger.Logf("Marked tests missing from the report:\n")
+			for _, test := range unreportedTests {
+				analysisLogger.Logf("Line %s: %s\n", testRun.LogLines.Location(test.Queries[0].LineNumber), test.Id)
+			}
+			analysisLogger.Log("\n")
+		}
+		analysisLogger.Log(analysisReportSeparator)
+	}
+
+	if len(queryCollection.ParseFailures) > 0 {
+		analysisLogger.Logf("Parse failures:\n\n")
+		for _, failure := range groupParseFailures(queryCollection.ParseFailures) {
+			analysisLogger.Logf("Parse error: %s\nNumber of queries: %d\n", failure.First, len(failure.Second))
+			for _, query := range failure.Second {
+				location := fmt.Sprintf("Line %s", testRun.LogLines.Location(query.LineNumber))
+				if query.TestId != "" {
+					location += fmt.Sprintf(", test %s", query.TestId)
+				}
+				analysisLogger.Logf("%s\n%s;\n", location, query.Text)
+			}
+			analysisLogger.Log("\n")
+		}
+		analysisLogger.Log(analysisReportSeparator)
+	}
+
+	if len(failedSchemaChanges) > 0 {
+		analysisLogger.Logf("Schema changes that couldn't be applied:\n\n")
+		for _, change := range failedSchemaChanges {
+			analysisLogger.Logf("%s\n", change.String(testRun.LogLines))
+		}
+		analysisLogger.Log(analysisReportSeparator)
+	}
+
+	analysisLogger.Logf("Query fingerprints:\n\n")
+	for _, fingerprint := range sortFingerprints(queryCollection) {
+		analysisLogger.Logf("%s, %d queries\n%s\n\n", fingerprint.First.Id, fingerprint.Second, fingerprint.First.Text)
+	}
+	analysisLogger.Log(analysisReportSeparator)
+
+	analysisLogger.Logf("Slowest query shapes:\n\n")
+	for _, shape := range slowestShapes(queryCollection.ByFingerprint, settings.slowestCount) {
+		fingerprint := queryCollection.ByFingerprint[shape.First][0].Fingerprint
+		analysisLogger.Logf("Fingerprint %s\nDurations: %s\n%s\n\n", fingerprint.Id, shape.Second, fingerprint.Text)
+	}
+	analysisLogger.Log(analysisReportSeparator)
+
+	analysisLogger.Logf("Slowest queries:\n\n")
+	for _, query := range slowestQueries(queryCollection.All, settings.slowestCount) {
+		analysisLogger.Logf("Duration: %s\n%s\n", query.Duration, query.String(settings.logQueryText, testRun.LogLines))
+	}
+	analysisLogger.Log(analysisReportSeparator)
+
+	if len(testRun.Tests) > 0 {
+		analysisLogger.Logf("Slowest tests:\n\n")
+		for _, test := range slowestTests(testRun.Tests, settings.slowestCount) {
+			analysisLogger.Logf("%s\nDurations: %s\n\n", test.First, test.Second)
+		}
+		analysisLogger.Log(analysisReportSeparator)
+	}
+
 	sortedListOfTestQueries := sortQueries(queryCollection)
 
 	for _, pair := range sortedListOfTestQueries {
-		dbg := pair.First
+		fingerprint := pair.First
 		queries := pair.Second
 
-		analysisLogger.Logf("Debug string: \n%s\n", dbg)
+		analysisLogger.Logf("Fingerprint %s\n%s\n", fingerprint.Id, fingerprint.Text)
 		analysisLogger.Logf("Number of queries: %d\n", len(queries))
+		analysisLogger.Logf("Durations: %s\n", NewDurationStats(queryCollection.ByFingerprint[fingerprint.Id]))
 
 		for index, query := range queries {
-			analysisLogger.Logf("Query %d/%d:\n%s\n", index+1, len(queries), query.String(settings.logQueryText))
+			analysisLogger.Logf("Query %d/%d:\n%s\n", index+1, len(queries), query.String(settings.logQueryText, testRun.LogLines))
 		}
 
 		analysisLogger.Logf(analysisReportSeparator)
@@ -455,9 +862,46 @@ func AnalyzeTestRun(settings Settings) (AnalysisOutput, error) {
 	return result, nil
 }
 
-func sortQueries(queryCollection QueryCollection) []Pair[string, []Query] {
-	sortedListOfTestQueries := []Pair[string, []Query]{}
-	for dbg, queries := range queryCollection.ByDebugString {
+// groupParseFailures groups the queries that couldn't be parsed by their parse error, most common first.
+func groupParseFailures(queries []Query) []Pair[string, []Query] {
+	byError := make(map[string][]Query)
+	for _, query := range queries {
+		byError[query.ParseError] = append(byError[query.ParseError], query)
+	}
+	groups := make([]Pair[string, []Query], 0, len(byError))
+	for parseError, queries := range byError {
+		groups = append(groups, Pair[string, []Query]{parseError, queries})
+	}
+	sort.SliceStable(groups, func(i, j int) bool {
+		left, right := groups[i], groups[j]
+		if len(left.Second) != len(right.Second) {
+			return len(left.Second) > len(right.Second)
+		}
+		return left.First < right.First
+	})
+	return groups
+}
+
+// sortFingerprints returns every fingerprint with its number of queries, most common first.
+func sortFingerprints(queryCollection QueryCollection) []Pair[Fingerprint, int] {
+	fingerprints := make([]Pair[Fingerprint, int], 0, len(queryCollection.ByFingerprint))
+	for _, queries := range queryCollection.ByFingerprint {
+		fingerprints = append(fingerprints, Pair[Fingerprint, int]{queries[0].Fingerprint, len(queries)})
+	}
+	sort.SliceStable(fingerprints, func(i, j int) bool {
+		left, right := fingerprints[i], fingerprints[j]
+		if left.Second != right.Second {
+			return left.Second > right.Second
+		}
+		return left.First.Id < right.First.Id
+	})
+	return fingerprints
+}
+
+// sortQueries groups the test queries by their fingerprint.
+func sortQueries(queryCollection QueryCollection) []Pair[Fingerprint, []Query] {
+	sortedListOfTestQueries := []Pair[Fingerprint, []Query]{}
+	for _, queries := range queryCollection.ByFingerprint {
 		testQueries := make([]Query, 0)
 		for _, query := range queries {
 			if query.TestId != "" {
@@ -465,7 +909,7 @@ func sortQueries(queryCollection QueryCollection) []Pair[string, []Query] {
 			}
 		}
 		if len(testQueries) > 0 {
-			pair := Pair[string, []Query]{dbg, testQueries}
+			pair := Pair[Fingerprint, []Query]{testQueries[0].Fingerprint, testQueries}
 			sortedListOfTestQueries = append(sortedListOfTestQueries, pair)
 		}
 	}
@@ -473,7 +917,7 @@ func sortQueries(queryCollection QueryCollection) []Pair[string, []Query] {
 	// sort list of queries by:
 	// 1. number of failed tests
 	// 2. number of queries
-	// 3. debug string
+	// 3. fingerprint id
 	countFailed := func(queries Query) bool {
 		return queries.TestFailed
 	}
@@ -492,7 +936,7 @@ func sortQueries(queryCollection QueryCollection) []Pair[string, []Query] {
 		case len(leftQueries) != len(rightQueries):
 			return len(leftQueries) > len(rightQueries)
 		default:
-			return leftPair.First < rightPair.First
+			return leftPair.First.Id < rightPair.First.Id
 		}
 	})
 	return sortedListOfTestQueries
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"fmt"
+	"github.com/dolthub/go-mysql-server/sql"
+	"golang.org/x/exp/slices"
+	"strings"
+	"time"
+)
+
+// Connection is a single client connection to the dolt server, from its NewConnection to its ConnectionClosed record.
+type Connection struct {
+	Id int
+	// OpenLineNumber is zero if the NewConnection record isn't in the log
+	OpenLineNumber int
+	OpenedAt       time.Time
+	// CloseLineNumber is zero if the connection was never closed
+	CloseLineNumber              int
+	ClosedAt                     time.Time
+	DisableClientMultiStatements string
+	// Database is the connectionDb of the connection's latest query
+	Database string
+	// Databases lists every connectionDb the connection's queries ran against, in order of first use
+	Databases      []string
+	Queries        []Query
+	ErrorCount     int
+	TotalQueryTime time.Duration
+	// Session is the session state after the connection's last query
+	Session *SessionState
+}
+
+func (c *Connection) Closed() bool {
+	return c.CloseLineNumber != 0
+}
+
+func (c *Connection) String(lines *LogLines) string {
+	sb := strings.Builder{}
+	sb.WriteString(fmt.Sprintf("Connection %d\n", c.Id))
+	if c.OpenLineNumber != 0 {
+		sb.WriteString(fmt.Sprintf("Opened: line %s, %s\n", lines.Location(c.OpenLineNumber), c.OpenedAt.Format(time.RFC3339)))
+	} else {
+		sb.WriteString("Opened: before the start of the log\n")
+	}
+	if c.Closed() {
+		sb.WriteString(fmt.Sprintf("Closed: line %s, %s\n", lines.Location(c.CloseLineNumber), c.ClosedAt.Format(time.RFC3339)))
+		if c.OpenLineNumber != 0 {
+			sb.WriteString(fmt.Sprintf("Lifetime: %s\n", c.ClosedAt.Sub(c.OpenedAt)))
+		}
+	} else {
+		sb.WriteString("Closed: never\n")
+	}
+	sb.WriteString(fmt.Sprintf("Databases: %s\n", strings.Join(c.Databases, ", ")))
+	sb.WriteString(fmt.Sprintf("Queries: %d, errors: %d, total query time: %s\n", len(c.Queries), c.ErrorCount, c.TotalQueryTime))
+	if c.Session != nil {
+		sb.WriteString(fmt.Sprintf("Final session: %s\n", c.Session))
+	}
+	sb.WriteString("\n")
+
+	for _, query := range c.Queries {
+		sb.WriteString(fmt.Sprintf("Line %s", lines.Location(query.LineNumber)))
+		if query.TestId != "" {
+			sb.WriteString(fmt.Sprintf(", test %s", query.TestId))
+		}
+		if query.Error != "" {
+			sb.WriteString(fmt.Sprintf(", error: %s", query.Error))
+		} else {
+			sb.WriteString(fmt.Sprintf(", %s", query.Duration))
+		}
+		sb.WriteString(fmt.Sprintf("\n%s;\n", query.Text))
+	}
+
+	return sb.String()
+}
+
+// connectionTracker builds Connections from the connection and query records of a log.
+type connectionTracker struct {
+	open        map[int]*Connection
+	connections []*Connection
+}
+
+func newConnectionTracker() *connectionTracker {
+	return &connectionTracker{
+		open:        make(map[int]*Connection),
+		connections: make([]*Connection, 0),
+	}
+}
+
+func (t *connectionTracker) opened(record LogRecord) {
+	connection := &Connection{
+		Id:                           record.ConnectionId,
+		OpenLineNumber:               record.LineNumber,
+		OpenedAt:                     record.Timestamp,
+		DisableClientMultiStatements: record.Fields.GetOrEmpty("DisableClientMultiStatements"),
+	}
+	t.open[record.ConnectionId] = connection
+	t.connections = append(t.connections, connection)
+}
+
+func (t *connectionTracker) closed(record LogRecord) {
+	connection := t.get(record.ConnectionId)
+	connection.CloseLineNumber = record.LineNumber
+	connection.ClosedAt = record.Timestamp
+	delete(t.open, record.ConnectionId)
+}
+
+func (t *connectionTracker) addQuery(query Query) {
+	connection := t.get(query.ConnectionId)
+	connection.Queries = append(connection.Queries, query)
+	if query.Error != "" {
+		connection.ErrorCount++
+	}
+	connection.TotalQueryTime += query.Duration
+	if query.ConnectionDb != "" {
+		connection.Database = query.ConnectionDb
+		if !slices.Contains(connection.Databases, query.ConnectionDb) {
+			connection.Databases = append(connection.Databases, query.ConnectionDb)
+		}
+	}
+}
+
+// replaySession replays the query into the connection's session state if it succeeded,
+// and returns the session states before and after the query ran.
+func (t *connectionTracker) replaySession(record LogRecord, node sql.Node, queryError string) (*SessionState, *SessionState) {
+	connection := t.get(record.ConnectionId)
+	if connection.Session == nil {
+		connection.Session = NewSessionState(record.ConnectionDb())
+	}
+	before := connection.Session
+	if queryError == "" {
+		connection.Session = before.Apply(node)
+	}
+	return before, connection.Session
+}
+
+// get returns the open connection with the given id, creating it if the log doesn't have its NewConnection record.
+func (t *connectionTracker) get(connectionId int) *Connection {
+	connection, ok := t.open[connectionId]
+	if !ok {
+		connection = &Connection{Id: connectionId}
+		t.open[connectionId] = connection
+		t.connections = append(t.connections, connection)
+	}
+	return connection
+}
+
+func (t *connectionTracker) end() []Connection {
+	connections := make([]Connection, len(t.connections))
+	for i, connection := range t.connections {
+		connections[i] = *connection
+	}
+	return connections
+}
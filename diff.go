@@ -0,0 +1,390 @@
+package main
+
+import (
+	"fmt"
+	"golang.org/x/exp/slices"
+	"os"
+	"sort"
+	"strings"
+)
+
+const (
+	// testNotRun is the status of a test that isn't in a run
+	testNotRun TestStatus = "not run"
+	// testRanUnreported is the status of a test that sent markers, in a run without a report that tells its result
+	testRanUnreported TestStatus = "ran"
+)
+
+// TestDiff is how a test differs between a base run and a compared run of the same suite.
+type TestDiff struct {
+	Id             string
+	BaseStatus     TestStatus
+	ComparedStatus TestStatus
+	// Removed are the queries only the base run sent, Added are the queries only the compared run sent
+	Removed []Query
+	Added   []Query
+	// ErrorChanges are the queries of the same shape, aligned between the runs, that errored differently
+	ErrorChanges []Pair[Query, Query]
+}
+
+// StatusChanged returns whether the test has another status in the compared run. A run without a report only tells
+// that the test ran, which is taken to match the statuses of the other run that don't fail it.
+func (d *TestDiff) StatusChanged() bool {
+	if d.BaseStatus == testRanUnreported {
+		return d.ComparedStatus == testNotRun || d.ComparedStatus.Failed()
+	}
+	if d.ComparedStatus == testRanUnreported {
+		return d.BaseStatus == testNotRun || d.BaseStatus.Failed()
+	}
+	return d.BaseStatus != d.ComparedStatus
+}
+
+func (d *TestDiff) QueriesChanged() bool {
+	return len(d.Removed) > 0 || len(d.Added) > 0 || len(d.ErrorChanges) > 0
+}
+
+func (d *TestDiff) String(baseLines *LogLines, comparedLines *LogLines) string {
+	sb := strings.Builder{}
+	sb.WriteString(fmt.Sprintf("Test %s\n", d.Id))
+	if d.StatusChanged() {
+		sb.WriteString(fmt.Sprintf("Status: %s -> %s\n", d.BaseStatus, d.ComparedStatus))
+	} else {
+		sb.WriteString(fmt.Sprintf("Status: %s\n", d.BaseStatus))
+	}
+	for _, query := range d.Removed {
+		sb.WriteString(fmt.Sprintf("- base line %s: %s\n", baseLines.Location(query.LineNumber), query.Text))
+	}
+	for _, query := range d.Added {
+		sb.WriteString(fmt.Sprintf("+ compared line %s: %s\n", comparedLines.Location(query.LineNumber), query.Text))
+	}
+	for _, change := range d.ErrorChanges {
+		sb.WriteString(fmt.Sprintf("! base line %s, compared line %s: %s\n", baseLines.Location(change.First.LineNumber), comparedLines.Location(change.Second.LineNumber),
+			change.Second.Text))
+		sb.WriteString(fmt.Sprintf("  base error: %s\n  compared error: %s\n", errorOrNone(change.First.Error),
+			errorOrNone(change.Second.Error)))
+	}
+	return sb.String()
+}
+
+func errorOrNone(err string) string {
+	if err == "" {
+		return "none"
+	}
+	return err
+}
+
+// RunDiff is the difference between a base run and a compared run of the same suite.
+type RunDiff struct {
+	// Tests are the tests of either run that differ, in the order of the base run and then of the compared run
+	Tests []TestDiff
+	// ErrorsOnlyInBase and ErrorsOnlyInCompared are the errors, by query shape, that only one of the runs had,
+	// with the number of queries that had them
+	ErrorsOnlyInBase     []Pair[string, int]
+	ErrorsOnlyInCompared []Pair[string, int]
+	TestCount            int
+}
+
+// diffTestRuns aligns the tests of the runs by id, and the queries of each test by their fingerprints.
+func diffTestRuns(base TestRun, compared TestRun) RunDiff {
+	diff := RunDiff{}
+	baseIndex, comparedIndex := newRunIndex(base), newRunIndex(compared)
+	ids := baseIndex.ids
+	for _, id := range comparedIndex.ids {
+		if !baseIndex.known[id] {
+			ids = append(ids, id)
+		}
+	}
+	diff.TestCount = len(ids)
+
+	for _, id := range ids {
+		testDiff := TestDiff{
+			Id:             id,
+			BaseStatus:     baseIndex.status(id),
+			ComparedStatus: comparedIndex.status(id),
+		}
+		var baseQueries, comparedQueries []Query
+		if test, ok := baseIndex.tests[id]; ok {
+			baseQueries = test.Queries
+		}
+		if test, ok := comparedIndex.tests[id]; ok {
+			comparedQueries = test.Queries
+		}
+		for _, step := range alignQueries(baseQueries, comparedQueries) {
+			switch {
+			case step.First == nil:
+				testDiff.Added = append(testDiff.Added, *step.Second)
+			case step.Second == nil:
+				testDiff.Removed = append(testDiff.Removed, *step.First)
+			case step.First.Error != step.Second.Error:
+				testDiff.ErrorChanges = append(testDiff.ErrorChanges, Pair[Query, Query]{*step.First, *step.Second})
+			}
+		}
+		if testDiff.StatusChanged() || testDiff.QueriesChanged() {
+			diff.Tests = append(diff.Tests, testDiff)
+		}
+	}
+
+	baseErrors, comparedErrors := queryErrors(base.Queries.All), queryErrors(compared.Queries.All)
+	diff.ErrorsOnlyInBase = errorsOnlyIn(baseErrors, comparedErrors)
+	diff.ErrorsOnlyInCompared = errorsOnlyIn(comparedErrors, baseErrors)
+	return diff
+}
+
+// runIndex has the tests, the results and the failed tests of a run by id.
+type runIndex struct {
+	tests   map[string]Test
+	results map[string]TestResult
+	failed  map[string]bool
+	// ids are the ids of the tests that sent markers or were reported, in order, known has them as a set
+	ids   []string
+	known map[string]bool
+}
+
+func newRunIndex(run TestRun) runIndex {
+	index := runIndex{
+		tests:   map[string]Test{},
+		results: map[string]TestResult{},
+		failed:  map[string]bool{},
+		known:   map[string]bool{},
+	}
+	for _, test := range run.Tests {
+		index.tests[test.Id] = test
+		if !index.known[test.Id] {
+			index.known[test.Id] = true
+			index.ids = append(index.ids, test.Id)
+		}
+	}
+	for _, result := range run.TestResults {
+		// the first result of a test is the one of the pytest report, if it has the test
+		if _, ok := index.results[result.Id]; !ok {
+			index.results[result.Id] = result
+		}
+		if !index.known[result.Id] {
+			index.known[result.Id] = true
+			index.ids = append(index.ids, result.Id)
+		}
+	}
+	for _, id := range run.FailedTestIds {
+		index.failed[id] = true
+	}
+	return index
+}
+
+// status returns the status that the report of the run gives the test, or whether it ran at all.
+func (r runIndex) status(id string) TestStatus {
+	if result, ok := r.results[id]; ok {
+		return result.Status
+	}
+	if r.failed[id] {
+		return TestFailed
+	}
+	if _, ok := r.tests[id]; ok {
+		return testRanUnreported
+	}
+	return testNotRun
+}
+
+// alignQueries aligns the queries of the runs by the longest common subsequence of their fingerprints. Each step has
+// the query of the base run, the query of the compared run, or both when they have the same fingerprint.
+func alignQueries(base []Query, compared []Query) []Pair[*Query, *Query] {
+	steps := []Pair[*Query, *Query]{}
+	// runs of the same suite mostly send the same queries, so the common prefix and suffix are aligned without
+	// searching for the subsequence
+	prefix := 0
+	for prefix < len(base) && prefix < len(compared) && base[prefix].Fingerprint.Id == compared[prefix].Fingerprint.Id {
+		steps = append(steps, Pair[*Query, *Query]{&base[prefix], &compared[prefix]})
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(base)-prefix && suffix < len(compared)-prefix &&
+		base[len(base)-1-suffix].Fingerprint.Id == compared[len(compared)-1-suffix].Fingerprint.Id {
+		suffix++
+	}
+	baseMiddle, comparedMiddle := base[prefix:len(base)-suffix], compared[prefix:len(compared)-suffix]
+
+	baseIds, comparedIds := make([]string, len(baseMiddle)), make([]string, len(comparedMiddle))
+	for i := range baseMiddle {
+		baseIds[i] = baseMiddle[i].Fingerprint.Id
+	}
+	for j := range comparedMiddle {
+		comparedIds[j] = comparedMiddle[j].Fingerprint.Id
+	}
+	alignFingerprints(baseIds, comparedIds, 0, 0, func(i int, j int) {
+		step := Pair[*Query, *Query]{}
+		if i >= 0 {
+			step.First = &baseMiddle[i]
+		}
+		if j >= 0 {
+			step.Second = &comparedMiddle[j]
+		}
+		steps = append(steps, step)
+	})
+
+	for k := len(base) - suffix; k < len(base); k++ {
+		steps = append(steps, Pair[*Query, *Query]{&base[k], &compared[k-len(base)+len(compared)]})
+	}
+	return steps
+}
+
+// alignFingerprints aligns the fingerprints by their longest common subsequence with Hirschberg's algorithm, which
+// needs memory linear in the number of queries rather than a table of the subsequence lengths, so that tests with
+// many queries can be aligned. It calls emit in order with the indexes of each step, -1 for the side it's missing on.
+func alignFingerprints(base []string, compared []string, baseOffset int, comparedOffset int, emit func(i int, j int)) {
+	switch {
+	case len(base) == 0:
+		for j := range compared {
+			emit(-1, comparedOffset+j)
+		}
+	case len(compared) == 0:
+		for i := range base {
+			emit(baseOffset+i, -1)
+		}
+	case len(base) == 1:
+		match := slices.Index(compared, base[0])
+		if match < 0 {
+			emit(baseOffset, -1)
+		}
+		for j := range compared {
+			if j == match {
+				emit(baseOffset, comparedOffset+j)
+			} else {
+				emit(-1, comparedOffset+j)
+			}
+		}
+	default:
+		// split the compared fingerprints where the subsequence of the first half of the base ones ends
+		middle := len(base) / 2
+		forward := commonSubsequenceLengths(base[:middle], compared, false)
+		backward := commonSubsequenceLengths(base[middle:], compared, true)
+		split, longest := 0, int32(-1)
+		for k := 0; k <= len(compared); k++ {
+			if length := forward[k] + backward[len(compared)-k]; length > longest {
+				split, longest = k, length
+			}
+		}
+		alignFingerprints(base[:middle], compared[:split], baseOffset, comparedOffset, emit)
+		alignFingerprints(base[middle:], compared[split:], baseOffset+middle, comparedOffset+split, emit)
+	}
+}
+
+// commonSubsequenceLengths returns the lengths of the longest common subsequences of base and each prefix of
+// compared, by the length of the prefix. Reversed, it's of the reversed fingerprints, so of the suffixes.
+func commonSubsequenceLengths(base []string, compared []string, reversed bool) []int32 {
+	at := func(fingerprints []string, i int) string {
+		if reversed {
+			return fingerprints[len(fingerprints)-1-i]
+		}
+		return fingerprints[i]
+	}
+	previous, current := make([]int32, len(compared)+1), make([]int32, len(compared)+1)
+	for i := range base {
+		for j := 1; j <= len(compared); j++ {
+			switch {
+			case at(base, i) == at(compared, j-1):
+				current[j] = previous[j-1] + 1
+			case previous[j] >= current[j-1]:
+				current[j] = previous[j]
+			default:
+				current[j] = current[j-1]
+			}
+		}
+		previous, current = current, previous
+	}
+	return previous
+}
+
+// queryErrors counts the errored queries by their shape and error.
+func queryErrors(queries []Query) map[string]int {
+	errors := map[string]int{}
+	for _, query := range queries {
+		if query.Error != "" {
+			errors[fmt.Sprintf("%s\n%s\n%s", query.Error, query.Fingerprint.Id, query.Fingerprint.Text)]++
+		}
+	}
+	return errors
+}
+
+// errorsOnlyIn returns the errors that only the first run had, most frequent first.
+func errorsOnlyIn(errors map[string]int, otherErrors map[string]int) []Pair[string, int] {
+	result := []Pair[string, int]{}
+	for key, count := range errors {
+		if _, ok := otherErrors[key]; !ok {
+			result = append(result, Pair[string, int]{key, count})
+		}
+	}
+	sort.SliceStable(result, func(i, j int) bool {
+		if result[i].Second != result[j].Second {
+			return result[i].Second > result[j].Second
+		}
+		return result[i].First < result[j].First
+	})
+	return result
+}
+
+// DiffOutput is the output of a diff of two runs.
+type DiffOutput struct {
+	diffOutputPath string
+}
+
+// DiffTestRuns analyzes the base and the compared run, and writes how they differ next to the output of the
+// compared run.
+func DiffTestRuns(baseSettings Settings, comparedSettings Settings) (DiffOutput, error) {
+	result := DiffOutput{}
+	base, err := parseTestRun(baseSettings)
+	if err != nil {
+		return result, err
+	}
+	compared, err := parseTestRun(comparedSettings)
+	if err != nil {
+		return result, err
+	}
+	diff := diffTestRuns(base, compared)
+
+	diffOutputPath := comparedSettings.GetOutputFilePath(".diff")
+	diffOutput, err := os.Create(diffOutputPath)
+	if err != nil {
+		return result, err
+	}
+	defer diffOutput.Close()
+	diffLogger := NewProxyLogger(NewFileLogger(diffOutput), comparedSettings.logger)
+
+	diffLogger.Logf("Base: %s\n", strings.Join(baseSettings.doltLogPaths, ", "))
+	diffLogger.Logf("Compared: %s\n", strings.Join(comparedSettings.doltLogPaths, ", "))
+	diffLogger.Logf("Tests: %d\n", diff.TestCount)
+	diffLogger.Logf("Tests only in base: %d\n", Count(diff.Tests, func(d TestDiff) bool {
+		return d.ComparedStatus == testNotRun
+	}))
+	diffLogger.Logf("Tests only in compared: %d\n", Count(diff.Tests, func(d TestDiff) bool {
+		return d.BaseStatus == testNotRun
+	}))
+	diffLogger.Logf("Tests with a changed status: %d\n", Count(diff.Tests, func(d TestDiff) bool {
+		return d.StatusChanged()
+	}))
+	diffLogger.Logf("Tests with changed queries: %d\n", Count(diff.Tests, func(d TestDiff) bool {
+		return d.QueriesChanged()
+	}))
+	diffLogger.Logf("Errors only in base: %d\n", len(diff.ErrorsOnlyInBase))
+	diffLogger.Logf("Errors only in compared: %d\n", len(diff.ErrorsOnlyInCompared))
+	diffLogger.Log(analysisReportSeparator)
+
+	for _, errors := range []Pair[string, []Pair[string, int]]{
+		{"Errors only in base", diff.ErrorsOnlyInBase},
+		{"Errors only in compared", diff.ErrorsOnlyInCompared},
+	} {
+		if len(errors.Second) == 0 {
+			continue
+		}
+		diffLogger.Logf("%s:\n\n", errors.First)
+		for _, queryError := range errors.Second {
+			diffLogger.Logf("%s\nNumber of queries: %d\n\n", queryError.First, queryError.Second)
+		}
+		diffLogger.Log(analysisReportSeparator)
+	}
+
+	for _, testDiff := range diff.Tests {
+		diffLogger.Log(testDiff.String(base.LogLines, compared.LogLines))
+		diffLogger.Log(analysisReportSeparator)
+	}
+	result.diffOutputPath = diffOutputPath
+	return result, nil
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"github.com/dolthub/vitess/go/vt/sqlparser"
+	"regexp"
+	"strings"
+)
+
+// Fingerprint is the shape of a query with the details that vary between executions of the same code path
+// normalized away, in the spirit of pt-query-digest: literals become ?, IN and VALUES lists are collapsed,
+// and savepoint names, table aliases, column aliases and comments are normalized.
+type Fingerprint struct {
+	// Id is a short hash of Text. It only depends on the query text, so it's stable across runs.
+	Id   string
+	Text string
+}
+
+func (f Fingerprint) String() string {
+	return fmt.Sprintf("%s %s", f.Id, f.Text)
+}
+
+// NewFingerprint returns the fingerprint of a query. Queries that vitess can't parse are fingerprinted textually.
+func NewFingerprint(query string) Fingerprint {
+	var text string
+	stmt, err := sqlparser.Parse(query)
+	if err == nil {
+		normalizeStatement(stmt)
+		text = sqlparser.String(stmt)
+	} else {
+		text = fingerprintText(query)
+	}
+	text = strings.Join(strings.Fields(text), " ")
+	return Fingerprint{Id: fingerprintId(text), Text: text}
+}
+
+// fingerprintId is the last 16 hex digits of the MD5 of the fingerprint text, like pt-query-digest's query ids.
+func fingerprintId(text string) string {
+	sum := md5.Sum([]byte(text))
+	digest := strings.ToUpper(hex.EncodeToString(sum[:]))
+	return "0x" + digest[len(digest)-16:]
+}
+
+var fingerprintPlaceholder = []byte("?")
+
+// normalizeStatement rewrites a parsed statement in place.
+func normalizeStatement(stmt sqlparser.Statement) {
+	tableAliases := make(map[string]string)
+	columnAliases := make(map[string]string)
+
+	// the first pass names aliases in order of appearance, so that references to them can be renamed in the second
+	_ = sqlparser.Walk(func(node sqlparser.SQLNode) (bool, error) {
+		switch node := node.(type) {
+		case *sqlparser.AliasedTableExpr:
+			alias := node.As.String()
+			if alias != "" {
+				if _, ok := tableAliases[strings.ToLower(alias)]; !ok {
+					tableAliases[strings.ToLower(alias)] = fmt.Sprintf("t%d", len(tableAliases)+1)
+				}
+			}
+		case *sqlparser.AliasedExpr:
+			alias := node.As.String()
+			if alias != "" {
+				if _, ok := columnAliases[strings.ToLower(alias)]; !ok {
+					columnAliases[strings.ToLower(alias)] = fmt.Sprintf("c%d", len(columnAliases)+1)
+				}
+			}
+		}
+		return true, nil
+	}, stmt)
+
+	_ = sqlparser.Walk(func(node sqlparser.SQLNode) (bool, error) {
+		switch node := node.(type) {
+		case *sqlparser.SQLVal:
+			node.Type = sqlparser.ValArg
+			node.Val = fingerprintPlaceholder
+		case *sqlparser.ComparisonExpr:
+			if node.Operator == sqlparser.InStr || node.Operator == sqlparser.NotInStr {
+				if _, ok := node.Right.(sqlparser.ValTuple); ok {
+					node.Right = sqlparser.ListArg("(?+)")
+				}
+			}
+		case *sqlparser.Insert:
+			node.Comments = nil
+			if values, ok := node.Rows.(sqlparser.Values); ok && len(values) > 1 {
+				node.Rows = values[:1]
+			}
+		case *sqlparser.AliasedTableExpr:
+			if alias, ok := tableAliases[strings.ToLower(node.As.String())]; ok {
+				node.As = sqlparser.NewTableIdent(alias)
+			}
+		case *sqlparser.AliasedExpr:
+			// the input expression is the original text of the expression, literals and all
+			node.InputExpression = ""
+			if alias, ok := columnAliases[strings.ToLower(node.As.String())]; ok {
+				node.As = sqlparser.NewColIdent(alias)
+			}
+		case *sqlparser.ColName:
+			if alias, ok := tableAliases[strings.ToLower(node.Qualifier.Name.String())]; ok && node.Qualifier.Qualifier.IsEmpty() {
+				node.Qualifier.Name = sqlparser.NewTableIdent(alias)
+			} else if alias, ok := columnAliases[node.Name.Lowered()]; ok && node.Qualifier.IsEmpty() {
+				node.Name = sqlparser.NewColIdent(alias)
+			}
+		case *sqlparser.Savepoint:
+			node.Identifier = "?"
+		case *sqlparser.RollbackSavepoint:
+			node.Identifier = "?"
+		case *sqlparser.ReleaseSavepoint:
+			node.Identifier = "?"
+		case *sqlparser.Select:
+			node.Comments = nil
+		case *sqlparser.Update:
+			node.Comments = nil
+		case *sqlparser.Delete:
+			node.Comments = nil
+		}
+		return true, nil
+	}, stmt)
+}
+
+var (
+	fingerprintCommentRegex = regexp.MustCompile(`(?s)/\*.*?\*/|(?m)(--|#)[^\n]*$`)
+	fingerprintStringRegex  = regexp.MustCompile(`'(?:[^'\\]|\\.|'')*'|"(?:[^"\\]|\\.|"")*"`)
+	fingerprintNumberRegex  = regexp.MustCompile(`\b(?:0x[0-9a-fA-F]+|[0-9]+(?:\.[0-9]+)?(?:[eE][-+]?[0-9]+)?)\b`)
+	fingerprintListRegex    = regexp.MustCompile(`\(\s*\?(?:\s*,\s*\?)*\s*\)`)
+	fingerprintValuesRegex  = regexp.MustCompile(`(?i)\b(values\s*)\(\?\+\)(?:\s*,\s*\(\?\+\))*`)
+)
+
+// fingerprintText normalizes a query that couldn't be parsed, with the same textual rules pt-query-digest uses.
+func fingerprintText(query string) string {
+	text := fingerprintCommentRegex.ReplaceAllString(query, "")
+	text = fingerprintStringRegex.ReplaceAllString(text, "?")
+	text = fingerprintNumberRegex.ReplaceAllString(text, "?")
+	text = fingerprintListRegex.ReplaceAllString(text, "(?+)")
+	text = fingerprintValuesRegex.ReplaceAllString(text, "${1}(?+)")
+	return strings.ToLower(text)
+}
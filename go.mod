@@ -4,6 +4,8 @@ go 1.19
 
 require (
 	github.com/dolthub/go-mysql-server v0.14.1-0.20230323180110-e8b040614c18
+	github.com/dolthub/vitess v0.0.0-20230310225942-1731d057dc71
+	github.com/klauspost/compress v1.16.7
 	github.com/stretchr/testify v1.7.1
 	golang.org/x/exp v0.0.0-20230321023759-10a507213a29
 )
@@ -11,7 +13,6 @@ require (
 require (
 	github.com/cespare/xxhash v1.1.0 // indirect
 	github.com/davecgh/go-spew v1.1.1 // indirect
-	github.com/dolthub/vitess v0.0.0-20230310225942-1731d057dc71 // indirect
 	github.com/go-kit/kit v0.10.0 // indirect
 	github.com/golang/protobuf v1.5.2 // indirect
 	github.com/google/flatbuffers v2.0.6+incompatible // indirect
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"github.com/klauspost/compress/zstd"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// stdinPath is the log path that reads the log from stdin
+const stdinPath = "-"
+
+var (
+	gzipMagic = []byte{0x1f, 0x8b}
+	zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+)
+
+// expandLogPaths expands the globs in the given log paths. Paths matching several files are sorted by name,
+// otherwise the paths stay in the order they were given.
+func expandLogPaths(patterns []string) ([]string, error) {
+	paths := make([]string, 0, len(patterns))
+	for _, pattern := range patterns {
+		if pattern == stdinPath || !strings.ContainsAny(pattern, `*?[\`) {
+			paths = append(paths, pattern)
+			continue
+		}
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid log path %s: %w", pattern, err)
+		}
+		if len(matches) == 0 {
+			return nil, fmt.Errorf("no log files match %s", pattern)
+		}
+		paths = append(paths, matches...)
+	}
+	return paths, nil
+}
+
+// LogLines maps the line numbers of several logs read as a single stream back to the logs the lines are in.
+// It's safe to use while the stream is read.
+type LogLines struct {
+	mu         sync.Mutex
+	multiple   bool
+	paths      []string
+	firstLines []int
+}
+
+// Location returns the line number of a line of a single log, and the path of its log and its line number in that
+// log otherwise, e.g. dolt-sql.log.2:1834.
+func (l *LogLines) Location(lineNumber int) string {
+	if l == nil || !l.multiple {
+		return strconv.Itoa(lineNumber)
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	i := sort.SearchInts(l.firstLines, lineNumber+1) - 1
+	if i < 0 {
+		return strconv.Itoa(lineNumber)
+	}
+	return fmt.Sprintf("%s:%d", l.paths[i], lineNumber-l.firstLines[i]+1)
+}
+
+func (l *LogLines) add(path string, firstLine int) {
+	if path == stdinPath {
+		path = "stdin"
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.paths = append(l.paths, path)
+	l.firstLines = append(l.firstLines, firstLine)
+}
+
+// logInput reads several logs, compressed or not, as a single stream. Files are only opened once the ones
+// before them are read, and a line ending is added to files that don't end with one, so that lines of
+// consecutive files never run together.
+type logInput struct {
+	paths   []string
+	current io.Reader
+	closers []io.Closer
+	// needsLineEnding is set when the data read from the current file so far doesn't end with a line ending
+	needsLineEnding bool
+	// lineEndings is the number of line endings read so far, over all files
+	lineEndings int
+	lines       *LogLines
+}
+
+func openLogInput(paths []string) (*logInput, error) {
+	if len(paths) == 0 {
+		return nil, fmt.Errorf("no log file given")
+	}
+	input := &logInput{paths: paths, lines: &LogLines{multiple: len(paths) > 1}}
+	// the first file is opened right away, so that a missing log is reported before the analysis starts
+	if err := input.next(); err != nil {
+		return nil, err
+	}
+	return input, nil
+}
+
+func (l *logInput) Read(p []byte) (int, error) {
+	for l.current != nil {
+		n, err := l.current.Read(p)
+		if n > 0 {
+			l.needsLineEnding = p[n-1] != '\n'
+			l.lineEndings += bytes.Count(p[:n], []byte{'\n'})
+			return n, nil
+		}
+		if err == io.EOF {
+			if l.needsLineEnding && len(p) > 0 {
+				l.needsLineEnding = false
+				l.lineEndings++
+				p[0] = '\n'
+				return 1, nil
+			}
+			if err := l.closeCurrent(); err != nil {
+				return 0, err
+			}
+			if err := l.next(); err != nil {
+				return 0, err
+			}
+			continue
+		}
+		if err != nil {
+			return 0, err
+		}
+	}
+	return 0, io.EOF
+}
+
+// next opens the next log file, if there's one left.
+func (l *logInput) next() error {
+	if len(l.paths) == 0 {
+		return nil
+	}
+	path := l.paths[0]
+	l.paths = l.paths[1:]
+	l.needsLineEnding = false
+	l.lines.add(path, l.lineEndings+1)
+
+	var file io.Reader = os.Stdin
+	if path != stdinPath {
+		opened, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		l.closers = append(l.closers, opened)
+		file = opened
+	}
+	reader, err := l.decompress(file)
+	if err != nil {
+		return fmt.Errorf("reading %s: %w", path, err)
+	}
+	l.current = reader
+	return nil
+}
+
+// decompress detects gzip and zstd compressed input by their magic numbers.
+func (l *logInput) decompress(file io.Reader) (io.Reader, error) {
+	buffered := bufio.NewReader(file)
+	magic, _ := buffered.Peek(len(zstdMagic))
+	switch {
+	case bytes.HasPrefix(magic, gzipMagic):
+		reader, err := gzip.NewReader(buffered)
+		if err != nil {
+			return nil, err
+		}
+		l.closers = append(l.closers, reader)
+		return reader, nil
+	case bytes.HasPrefix(magic, zstdMagic):
+		decoder, err := zstd.NewReader(buffered)
+		if err != nil {
+			return nil, err
+		}
+		l.closers = append(l.closers, decoder.IOReadCloser())
+		return decoder, nil
+	default:
+		return buffered, nil
+	}
+}
+
+func (l *logInput) closeCurrent() error {
+	l.current = nil
+	return l.Close()
+}
+
+// Close closes the files that are open, it's safe to call more than once.
+func (l *logInput) Close() error {
+	var firstErr error
+	// decompressors are closed before the files they read from
+	for i := len(l.closers) - 1; i >= 0; i-- {
+		if err := l.closers[i].Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	l.closers = nil
+	return firstErr
+}
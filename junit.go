@@ -0,0 +1,119 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// junitTestCase is a <testcase> of a JUnit XML report, as written by pytest --junitxml, xmlrunner and CI systems:
+//
+//	<testcase classname="nautobot.dcim.tests.test_filters.PlatformTestCase" name="test_napalm_args" time="0.076">
+//	  <failure type="AssertionError" message="0 != 2">Traceback (most recent call last): ...</failure>
+//	</testcase>
+type junitTestCase struct {
+	Name      string         `xml:"name,attr"`
+	ClassName string         `xml:"classname,attr"`
+	Time      string         `xml:"time,attr"`
+	Failures  []junitProblem `xml:"failure"`
+	Errors    []junitProblem `xml:"error"`
+	Skipped   *junitProblem  `xml:"skipped"`
+}
+
+// junitProblem is a <failure>, <error> or <skipped> element, its text is the traceback if there is one.
+type junitProblem struct {
+	Type    string `xml:"type,attr"`
+	Message string `xml:"message,attr"`
+	Text    string `xml:",chardata"`
+}
+
+// pytestExpectedFailureType is the type of the <skipped> elements pytest writes for xfail tests
+const pytestExpectedFailureType = "pytest.xfail"
+
+// parseJUnitReport returns the results and the failures of the test cases of a JUnit XML report. Test cases can be
+// nested in any number of <testsuites> and <testsuite> elements.
+func parseJUnitReport(path string) (testResults []TestResult, testFailures []TestFailure, err error) {
+	input, err := os.Open(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer input.Close()
+
+	testResults = make([]TestResult, 0)
+	testFailures = make([]TestFailure, 0)
+	decoder := xml.NewDecoder(input)
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("reading %s: %w", path, err)
+		}
+		start, ok := token.(xml.StartElement)
+		if !ok || start.Name.Local != "testcase" {
+			continue
+		}
+		lineNumber, _ := decoder.InputPos()
+		var testCase junitTestCase
+		if err := decoder.DecodeElement(&testCase, &start); err != nil {
+			return nil, nil, fmt.Errorf("reading %s: %w", path, err)
+		}
+
+		result, failure := testCase.result()
+		result.Order = len(testResults) + 1
+		result.LineNumber = lineNumber
+		testResults = append(testResults, result)
+		if failure != nil {
+			failure.LineNumber = lineNumber
+			testFailures = append(testFailures, *failure)
+		}
+	}
+	return testResults, testFailures, nil
+}
+
+// result returns the result of the test case, and its failure if it failed or errored.
+func (c *junitTestCase) result() (TestResult, *TestFailure) {
+	result := TestResult{
+		Id:     testResultId(c.Name, c.ClassName),
+		Status: TestPassed,
+	}
+	if seconds, err := strconv.ParseFloat(c.Time, 64); err == nil {
+		result.Duration = time.Duration(seconds * float64(time.Second))
+	}
+
+	var problem *junitProblem
+	switch {
+	case len(c.Errors) > 0:
+		result.Status = TestErrored
+		problem = &c.Errors[0]
+	case len(c.Failures) > 0:
+		result.Status = TestFailed
+		problem = &c.Failures[0]
+	case c.Skipped != nil && c.Skipped.Type == pytestExpectedFailureType:
+		result.Status = TestExpectedFailure
+		result.Reason = c.Skipped.Message
+		return result, nil
+	case c.Skipped != nil:
+		result.Status = TestSkipped
+		result.Reason = c.Skipped.Message
+		return result, nil
+	default:
+		return result, nil
+	}
+
+	failure := &TestFailure{Id: result.Id, Status: result.Status}
+	parseTraceback(failure, problem.Text)
+	// tracebacks that aren't in the Python format, like the ones pytest writes, are only described by the message
+	if len(failure.Frames) == 0 {
+		failure.Exception = problem.Message
+		if problem.Type != "" && !strings.HasPrefix(problem.Message, problem.Type) {
+			failure.Exception = strings.TrimSuffix(problem.Type+": "+problem.Message, ": ")
+		}
+	}
+	return result, failure
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"io"
+)
+
+// defaultMaxLineLength is large enough for the base64-encoded bulk inserts and DOLT_PATCH results of test suites,
+// -max-line-length raises it for larger ones
+const defaultMaxLineLength = 16 * 1024 * 1024
+
+// LineReader reads lines of any length, unlike bufio.Scanner which fails on lines longer than 64 KiB.
+// Memory stays bounded by maxLineLength: the content of longer lines is discarded, and TooLong reports it.
+type LineReader struct {
+	reader        *bufio.Reader
+	maxLineLength int
+	line          []byte
+	tooLong       bool
+	err           error
+}
+
+func NewLineReader(reader io.Reader, maxLineLength int) *LineReader {
+	return &LineReader{
+		reader:        bufio.NewReaderSize(reader, 64*1024),
+		maxLineLength: maxLineLength,
+	}
+}
+
+// Next reads the next line, and returns false at the end of the input or on an error.
+func (r *LineReader) Next() bool {
+	if r.err != nil {
+		return false
+	}
+	r.line = r.line[:0]
+	r.tooLong = false
+	read := false
+	for {
+		chunk, err := r.reader.ReadSlice('\n')
+		read = read || len(chunk) > 0
+		// the line ending isn't part of the line, so there's room for it past the maximum length
+		if !r.tooLong && len(r.line)+len(chunk) > r.maxLineLength+len("\r\n") {
+			r.tooLong = true
+			r.line = r.line[:0]
+		}
+		if !r.tooLong {
+			r.line = append(r.line, chunk...)
+		}
+		if errors.Is(err, bufio.ErrBufferFull) {
+			continue
+		}
+		if err != nil {
+			r.err = err
+			if err != io.EOF {
+				return false
+			}
+			// the last line doesn't need a line ending
+			if !read {
+				return false
+			}
+		}
+		r.trimLineEnding()
+		if len(r.line) > r.maxLineLength {
+			r.tooLong = true
+			r.line = r.line[:0]
+		}
+		return true
+	}
+}
+
+func (r *LineReader) trimLineEnding() {
+	if len(r.line) > 0 && r.line[len(r.line)-1] == '\n' {
+		r.line = r.line[:len(r.line)-1]
+		if len(r.line) > 0 && r.line[len(r.line)-1] == '\r' {
+			r.line = r.line[:len(r.line)-1]
+		}
+	}
+}
+
+// Text returns the line read by the last call to Next, without its line ending. It's empty if the line was too long.
+func (r *LineReader) Text() string {
+	return string(r.line)
+}
+
+// TooLong returns whether the line read by the last call to Next was longer than the maximum line length.
+func (r *LineReader) TooLong() bool {
+	return r.tooLong
+}
+
+// Err returns the error that stopped the reader, if it wasn't the end of the input.
+func (r *LineReader) Err() error {
+	if r.err == io.EOF {
+		return nil
+	}
+	return r.err
+}
@@ -0,0 +1,421 @@
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+// LogFormat is the way a dolt release, or another server to compare dolt with, writes queries to its log. The layout of the lines themselves, text or JSON,
+// is detected for every line and doesn't depend on the format.
+type LogFormat interface {
+	// Name identifies the format on the command line
+	Name() string
+	// Description is shown in the usage of the -log-format flag
+	Description() string
+	// Detect returns whether a log starting with the given server banner was written in this format
+	Detect(banner ServerBanner) bool
+	// MultiLine returns whether a record continues on the following lines of the log until the next record starts
+	MultiLine() bool
+	// DecodeQuery returns the query text of the query field of a record
+	DecodeQuery(query string) (string, error)
+}
+
+// recordLogFormat is a format of a server other than dolt, whose records aren't dolt log lines. It splits the log
+// into records and parses them itself. Its servers log a query once rather than when it starts and when it
+// finishes, so its queries aren't paired.
+type recordLogFormat interface {
+	LogFormat
+	// IsRecordStart returns whether the line starts a record
+	IsRecordStart(line string) bool
+	// ContinuesRecord returns whether the line belongs to the record made of the lines of entry so far
+	ContinuesRecord(entry string, line string) bool
+	// ParseRecord parses a record, the lines of a multi-line record joined with "\n"
+	ParseRecord(lineNumber int, entry string) (LogRecord, bool)
+}
+
+// autoLogFormat is the -log-format value that detects the format from the server banner
+const autoLogFormat = "auto"
+
+// logFormats is the registry of formats
+var logFormats = []LogFormat{
+	base64LogFormat{},
+	plainLogFormat{},
+	multiLineLogFormat{},
+	mixedLogFormat{},
+	mysqlLogFormat{},
+}
+
+// undetectedLogFormat is used for logs without a server banner, or with a banner no format claims, like rotated logs,
+// logs concatenated mid-run and snippets. Their queries are read in the format of current releases.
+var undetectedLogFormat LogFormat = assumedLogFormat{base64LogFormat{}}
+
+// assumedLogFormat is a format that wasn't detected from a banner, so that the analysis can tell it was assumed.
+type assumedLogFormat struct {
+	LogFormat
+}
+
+// encodesQueries returns whether the queries of the format are encoded, so that they can't contain the separators
+// of the fields of a record.
+func encodesQueries(format LogFormat) bool {
+	switch format := format.(type) {
+	case base64LogFormat:
+		return true
+	case assumedLogFormat:
+		return encodesQueries(format.LogFormat)
+	}
+	return false
+}
+
+// RegisterLogFormat adds a format to the registry. Formats registered later take precedence when detecting,
+// so that a new format can claim the banners of releases that a built-in format claims too.
+func RegisterLogFormat(format LogFormat) {
+	if _, err := LogFormatByName(format.Name()); err == nil {
+		panic(fmt.Sprintf("log format %s is already registered", format.Name()))
+	}
+	logFormats = append(logFormats, format)
+}
+
+// LogFormatByName returns the registered format with the given name.
+func LogFormatByName(name string) (LogFormat, error) {
+	for _, format := range logFormats {
+		if format.Name() == name {
+			return format, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown log format %s, known formats are %s", name, strings.Join(LogFormatNames(), ", "))
+}
+
+// LogFormatNames returns the names of the registered formats, sorted.
+func LogFormatNames() []string {
+	names := make([]string, 0, len(logFormats))
+	for _, format := range logFormats {
+		names = append(names, format.Name())
+	}
+	sort.Strings(names)
+	return names
+}
+
+// logFormatForName returns the format to read a log with, nil means the format is detected from the log.
+func logFormatForName(name string) (LogFormat, error) {
+	if name == "" || name == autoLogFormat {
+		return nil, nil
+	}
+	return LogFormatByName(name)
+}
+
+// DetectLogFormat returns the most recently registered format that claims the banner.
+func DetectLogFormat(banner ServerBanner) LogFormat {
+	for i := len(logFormats) - 1; i >= 0; i-- {
+		if logFormats[i].Detect(banner) {
+			return logFormats[i]
+		}
+	}
+	return undetectedLogFormat
+}
+
+// logFormatUsage describes the formats for the -log-format flag.
+func logFormatUsage() string {
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("Format of the queries in the log, %s detects it from the server banner:", autoLogFormat))
+	for _, name := range LogFormatNames() {
+		format, _ := LogFormatByName(name)
+		sb.WriteString(fmt.Sprintf("\n  %s: %s", name, format.Description()))
+	}
+	return sb.String()
+}
+
+const serverBannerPrefix = "Starting server with Config "
+
+// ServerBanner is the config that dolt sql-server prints when it starts:
+//
+//	Starting server with Config HP="0.0.0.0:3306"|T="28800000"|R="false"|L="debug"
+//
+// The keys it prints changed between dolt releases. The version line that mysqld prints at the top of its logs is a
+// banner too, with the version as its only key:
+//
+//	/usr/sbin/mysqld, Version: 8.0.32 (MySQL Community Server - GPL). started with:
+type ServerBanner map[string]string
+
+// ParseServerBanner parses the banner line, it returns false for any other line.
+func ParseServerBanner(line string) (ServerBanner, bool) {
+	if strings.Contains(line, ", Version: ") {
+		if mysqldParse := RegexSplit(line, mysqldBannerRegex); mysqldParse != nil {
+			return ServerBanner{mysqldBannerKey: mysqldParse[0]}, true
+		}
+	}
+	if !strings.HasPrefix(line, serverBannerPrefix) {
+		return nil, false
+	}
+	banner := ServerBanner{}
+	for _, setting := range strings.Split(strings.TrimPrefix(line, serverBannerPrefix), "|") {
+		key, value, found := strings.Cut(setting, "=")
+		if !found {
+			continue
+		}
+		banner[key] = strings.Trim(value, `"`)
+	}
+	return banner, true
+}
+
+func (b ServerBanner) Has(key string) bool {
+	_, ok := b[key]
+	return ok
+}
+
+// base64LogFormat is the format of dolt releases that encode the logged queries with base64.
+type base64LogFormat struct{}
+
+func (base64LogFormat) Name() string {
+	return "base64"
+}
+
+func (base64LogFormat) Description() string {
+	return "queries are base64 encoded, written by current dolt releases"
+}
+
+// Detect claims the banners of current releases, which no longer print the user and password.
+func (base64LogFormat) Detect(banner ServerBanner) bool {
+	return !banner.Has("U") && !banner.Has("P") && !banner.Has(mysqldBannerKey)
+}
+
+func (base64LogFormat) MultiLine() bool {
+	return false
+}
+
+func (base64LogFormat) DecodeQuery(query string) (string, error) {
+	queryBytes, err := base64.StdEncoding.DecodeString(query)
+	if err != nil {
+		return query, fmt.Errorf("query is not base64 encoded: %w", err)
+	}
+	return string(queryBytes), nil
+}
+
+// plainLogFormat is the format of logs with queries that are written as they are and never span lines.
+type plainLogFormat struct{}
+
+func (plainLogFormat) Name() string {
+	return "plain"
+}
+
+func (plainLogFormat) Description() string {
+	return "queries are written as they are, lines that aren't records are ignored"
+}
+
+func (plainLogFormat) Detect(ServerBanner) bool {
+	return false
+}
+
+func (plainLogFormat) MultiLine() bool {
+	return false
+}
+
+func (plainLogFormat) DecodeQuery(query string) (string, error) {
+	return query, nil
+}
+
+// multiLineLogFormat is the format of older dolt releases, which wrote queries as they are, line breaks included.
+type multiLineLogFormat struct{}
+
+func (multiLineLogFormat) Name() string {
+	return "multiline"
+}
+
+func (multiLineLogFormat) Description() string {
+	return "queries are written as they are and continue on the following lines, written by older dolt releases"
+}
+
+// Detect claims the banners of older releases, which printed the user and password.
+func (multiLineLogFormat) Detect(banner ServerBanner) bool {
+	return banner.Has("U") || banner.Has("P")
+}
+
+func (multiLineLogFormat) MultiLine() bool {
+	return true
+}
+
+func (multiLineLogFormat) DecodeQuery(query string) (string, error) {
+	return query, nil
+}
+
+// mixedLogFormat is the format of logs written by several releases without banners between them. A query is base64
+// decoded when it's valid base64 of printable text, and taken as it is otherwise, so that a plain ROLLBACK isn't
+// decoded into binary. It's never detected, since a plain query can happen to decode to text.
+type mixedLogFormat struct{}
+
+func (mixedLogFormat) Name() string {
+	return "mixed"
+}
+
+func (mixedLogFormat) Description() string {
+	return "queries are base64 decoded when they decode to text and taken as they are otherwise, for logs of several releases"
+}
+
+func (mixedLogFormat) Detect(ServerBanner) bool {
+	return false
+}
+
+func (mixedLogFormat) MultiLine() bool {
+	return false
+}
+
+func (mixedLogFormat) DecodeQuery(query string) (string, error) {
+	queryBytes, err := base64.StdEncoding.DecodeString(query)
+	if err != nil || !isPrintableText(queryBytes) {
+		return query, nil
+	}
+	return string(queryBytes), nil
+}
+
+// isPrintableText returns whether the bytes are UTF-8 text without control characters other than whitespace.
+func isPrintableText(text []byte) bool {
+	if len(text) == 0 || !utf8.Valid(text) {
+		return false
+	}
+	for _, r := range string(text) {
+		if unicode.IsControl(r) && r != '\n' && r != '\r' && r != '\t' {
+			return false
+		}
+	}
+	return true
+}
+
+// logEntry is a line of the log, or the lines of a record of a multi-line format joined with "\n".
+type logEntry struct {
+	// LineNumber is the number of the first line of the entry
+	LineNumber int
+	Text       string
+	// TooLong is set when the entry is longer than the maximum line length, Text is empty then
+	TooLong bool
+	Format  LogFormat
+}
+
+// logEntryReader splits a log into entries, in the format given or the one detected from the server banner.
+// A banner past the start of the log, from a server restarted with another dolt release, switches the format.
+type logEntryReader struct {
+	lines         *LineReader
+	maxLineLength int
+	format        LogFormat
+	detect        bool
+	lineNumber    int
+	// next is the line read past the end of the last multi-line entry
+	next    *logEntry
+	current logEntry
+}
+
+// newLogEntryReader returns a reader in the given format, a nil format is detected.
+func newLogEntryReader(lines *LineReader, maxLineLength int, format LogFormat) *logEntryReader {
+	reader := &logEntryReader{
+		lines:         lines,
+		maxLineLength: maxLineLength,
+		format:        format,
+	}
+	if format == nil {
+		reader.format = undetectedLogFormat
+		reader.detect = true
+	}
+	return reader
+}
+
+// Next reads the next entry, and returns false at the end of the input or on an error.
+func (r *logEntryReader) Next() bool {
+	entry, ok := r.readLine()
+	if !ok {
+		return false
+	}
+	if !entry.TooLong && r.detect {
+		if banner, ok := ParseServerBanner(entry.Text); ok {
+			r.format = DetectLogFormat(banner)
+		}
+	}
+	entry.Format = r.format
+
+	if r.format.MultiLine() && !entry.TooLong && r.isRecordStart(entry.Text) {
+		var sb strings.Builder
+		sb.WriteString(entry.Text)
+		for {
+			line, ok := r.readLine()
+			if !ok {
+				break
+			}
+			if line.TooLong || !r.continuesRecord(sb.String(), line.Text) || isServerBanner(line.Text) {
+				r.next = &line
+				break
+			}
+			if entry.TooLong {
+				continue
+			}
+			if sb.Len()+len("\n")+len(line.Text) > r.maxLineLength {
+				entry.TooLong = true
+				sb.Reset()
+				continue
+			}
+			sb.WriteString("\n")
+			sb.WriteString(line.Text)
+		}
+		entry.Text = sb.String()
+	}
+
+	r.current = entry
+	return true
+}
+
+func (r *logEntryReader) readLine() (logEntry, bool) {
+	if r.next != nil {
+		line := *r.next
+		r.next = nil
+		return line, true
+	}
+	if !r.lines.Next() {
+		return logEntry{}, false
+	}
+	r.lineNumber++
+	return logEntry{LineNumber: r.lineNumber, Text: r.lines.Text(), TooLong: r.lines.TooLong()}, true
+}
+
+func (r *logEntryReader) isRecordStart(line string) bool {
+	if format, ok := r.format.(recordLogFormat); ok {
+		return format.IsRecordStart(line)
+	}
+	return isTextLogRecordStart(line)
+}
+
+func (r *logEntryReader) continuesRecord(entry string, line string) bool {
+	if format, ok := r.format.(recordLogFormat); ok {
+		return format.ContinuesRecord(entry, line)
+	}
+	return !isLogRecordStart(line)
+}
+
+// Entry returns the entry read by the last call to Next.
+func (r *logEntryReader) Entry() logEntry {
+	return r.current
+}
+
+// Err returns the error that stopped the reader, if it wasn't the end of the input.
+func (r *logEntryReader) Err() error {
+	return r.lines.Err()
+}
+
+// isLogRecordStart returns whether the line starts a record, in either layout.
+func isLogRecordStart(line string) bool {
+	return strings.HasPrefix(line, "{") || isTextLogRecordStart(line)
+}
+
+func isServerBanner(line string) bool {
+	_, ok := ParseServerBanner(line)
+	return ok
+}
+
+func isTextLogRecordStart(line string) bool {
+	headerParse := RegexSplit(line, logLineRegex)
+	if headerParse == nil {
+		return false
+	}
+	_, err := time.Parse(time.RFC3339, headerParse[0])
+	return err == nil
+}
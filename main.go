@@ -2,10 +2,27 @@ package main
 
 import (
 	"fmt"
+	"os"
 )
 
 func main() {
-	settings := readInputs()
+	if len(os.Args) > 1 && os.Args[1] == "diff" {
+		base, compared, err := readDiffInputs(os.Args[2:])
+		if err != nil {
+			panic(err)
+		}
+		result, err := DiffTestRuns(base, compared)
+		if err != nil {
+			panic(err)
+		}
+		fmt.Printf("Diff output: %s\n", result.diffOutputPath)
+		return
+	}
+
+	settings, err := readInputs()
+	if err != nil {
+		panic(err)
+	}
 	result, err := mainLogic(settings)
 	if err != nil {
 		panic(err)
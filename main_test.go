@@ -1,9 +1,26 @@
 package main
 
 import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
 	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"testing/iotest"
+	"time"
 
+	"github.com/dolthub/go-mysql-server/sql"
+	"github.com/dolthub/go-mysql-server/sql/expression"
+	"github.com/dolthub/go-mysql-server/sql/parse"
+	"github.com/dolthub/go-mysql-server/sql/plan"
+	"github.com/dolthub/go-mysql-server/sql/types"
+	"github.com/klauspost/compress/zstd"
 	"github.com/stretchr/testify/require"
 )
 
@@ -62,6 +79,8 @@ func TestSampleCase(t *testing.T) {
 
 	settings := NewSettings(input.Name(), "")
 	settings.logger = NewTestLogger(t)
+	// the lines come from releases that encode queries and from releases that don't
+	settings.logFormat = "mixed"
 
 	// Run the main logic
 	result, err := mainLogic(settings)
@@ -82,3 +101,1871 @@ func TestSampleCase(t *testing.T) {
 	require.Contains(t, outText, "django_content_type.app_label")
 	require.Contains(t, outText, "ipam_prefix.prefix_length ASC nullsFirst")
 }
+
+func TestParseLogRecord(t *testing.T) {
+	record, ok := ParseLogRecord(41, "2023-03-24T23:20:49Z WARN [conn 2] error running query {connectTime=2023-03-24T23:20:48Z, connectionDb=, error=can't create database test_nautobot; database exists, query=Q1JFQVRFIERBVEFCQVNFIGB0ZXN0X25hdXRvYm90YA==}")
+	require.True(t, ok)
+	require.Equal(t, RecordQueryError, record.Kind)
+	require.Equal(t, 41, record.LineNumber)
+	require.Equal(t, "WARN", record.Level)
+	require.Equal(t, 2, record.ConnectionId)
+	require.Equal(t, "2023-03-24T23:20:49Z", record.Timestamp.Format(time.RFC3339))
+	require.Equal(t, "2023-03-24T23:20:48Z", record.ConnectTime().Format(time.RFC3339))
+	require.Equal(t, "", record.ConnectionDb())
+	require.Equal(t, "can't create database test_nautobot; database exists", record.Error())
+	require.Equal(t, "Q1JFQVRFIERBVEFCQVNFIGB0ZXN0X25hdXRvYm90YA==", record.Query())
+
+	// commas inside values must not start new fields
+	record, ok = ParseLogRecord(1, "2023-03-24T23:20:49Z WARN [conn 2] error running query {connectTime=2023-03-24T23:20:48Z, connectionDb=test_nautobot, error=Duplicate entry, connectionDb=x for key 'PRIMARY', query=SELECT a, b=1, zone=2 FROM t}")
+	require.True(t, ok)
+	require.Equal(t, "Duplicate entry, connectionDb=x for key 'PRIMARY'", record.Error())
+	require.Equal(t, "SELECT a, b=1, zone=2 FROM t", record.Query())
+
+	// errors may quote the query, encoded queries can't contain ", query=" so the error runs to the last one
+	record, ok = parseLogLine(1, "2023-03-24T23:20:49Z WARN [conn 2] error running query {connectTime=2023-03-24T23:20:48Z, connectionDb=test_nautobot, error=syntax error near 'x, query=y', query=U0VMRUNUIHgsIHF1ZXJ5PXk=}", true)
+	require.True(t, ok)
+	require.Equal(t, "syntax error near 'x, query=y'", record.Error())
+	require.Equal(t, "U0VMRUNUIHgsIHF1ZXJ5PXk=", record.Query())
+
+	// queries that are written as they are may contain ", query=", the error ends at the first one
+	record, ok = ParseLogRecord(1, "2023-03-24T23:20:49Z WARN [conn 2] error running query {connectTime=2023-03-24T23:20:48Z, connectionDb=test_nautobot, error=boom, query=UPDATE t SET a=1, query=2}")
+	require.True(t, ok)
+	require.Equal(t, "boom", record.Error())
+	require.Equal(t, "UPDATE t SET a=1, query=2", record.Query())
+	require.True(t, encodesQueries(base64LogFormat{}))
+	require.True(t, encodesQueries(undetectedLogFormat))
+	require.False(t, encodesQueries(plainLogFormat{}))
+	require.False(t, encodesQueries(mixedLogFormat{}))
+
+	record, ok = ParseLogRecord(2, "2023-03-24T23:20:49Z DEBUG [conn 1] Query finished in 22 ms {connectTime=2023-03-24T23:20:49Z, connectionDb=nautobot, query=U0VUIE5BTUVTIHV0ZjhtYjQ=}")
+	require.True(t, ok)
+	require.Equal(t, RecordQueryFinished, record.Kind)
+	require.Equal(t, 22*time.Millisecond, record.Duration)
+	require.Equal(t, "nautobot", record.ConnectionDb())
+
+	record, ok = ParseLogRecord(3, "2023-03-24T23:20:49Z INFO [conn 1] NewConnection {DisableClientMultiStatements=false}")
+	require.True(t, ok)
+	require.Equal(t, RecordConnectionOpened, record.Kind)
+	require.Equal(t, "false", record.Fields.GetOrEmpty("DisableClientMultiStatements"))
+
+	record, ok = ParseLogRecord(4, "2023-03-24T23:20:49Z INFO [conn 1] ConnectionClosed {}")
+	require.True(t, ok)
+	require.Equal(t, RecordConnectionClosed, record.Kind)
+	require.Empty(t, record.Fields)
+
+	_, ok = ParseLogRecord(5, `Starting server with Config HP="0.0.0.0:3306"|T="28800000"|R="false"|L="debug"`)
+	require.False(t, ok)
+}
+
+func TestParseJSONLogRecord(t *testing.T) {
+	// the same records, written by the text and the JSON formatters
+	lines := [][2]string{
+		{
+			"2023-03-24T23:20:49Z WARN [conn 2] error running query {connectTime=2023-03-24T23:20:48Z, connectionDb=, error=can't create database test_nautobot; database exists, query=Q1JFQVRFIERBVEFCQVNFIGB0ZXN0X25hdXRvYm90YA==}",
+			`{"connectTime":"2023-03-24T23:20:48Z","connectionDb":"","connectionID":2,"error":"can't create database test_nautobot; database exists","level":"warning","msg":"error running query","query":"Q1JFQVRFIERBVEFCQVNFIGB0ZXN0X25hdXRvYm90YA==","time":"2023-03-24T23:20:49Z"}`,
+		},
+		{
+			"2023-03-24T23:20:49Z DEBUG [conn 1] Query finished in 22 ms {connectTime=2023-03-24T23:20:49Z, connectionDb=nautobot, query=U0VUIE5BTUVTIHV0ZjhtYjQ=}",
+			`{"connectTime":"2023-03-24T23:20:49Z","connectionDb":"nautobot","connectionID":1,"level":"debug","msg":"Query finished in 22 ms","query":"U0VUIE5BTUVTIHV0ZjhtYjQ=","time":"2023-03-24T23:20:49Z"}`,
+		},
+		{
+			"2023-03-24T23:20:49Z INFO [conn 1] NewConnection {DisableClientMultiStatements=false}",
+			`{"DisableClientMultiStatements":false,"connectionID":1,"level":"info","msg":"NewConnection","time":"2023-03-24T23:20:49Z"}`,
+		},
+		{
+			"2023-03-24T23:20:49Z INFO [conn 1] ConnectionClosed {}",
+			`{"connectionID":1,"level":"info","msg":"ConnectionClosed","time":"2023-03-24T23:20:49Z"}`,
+		},
+	}
+	for i, pair := range lines {
+		textRecord, ok := ParseLogLine(i, pair[0])
+		require.True(t, ok)
+		jsonRecord, ok := ParseLogLine(i, pair[1])
+		require.True(t, ok)
+		require.Equal(t, textRecord, jsonRecord)
+	}
+
+	_, ok := ParseLogLine(5, `{"msg":"no time or level"}`)
+	require.False(t, ok)
+	_, ok = ParseLogLine(6, `{"truncated":`)
+	require.False(t, ok)
+}
+
+func TestJSONLog(t *testing.T) {
+	// prepare
+	logs := []string{
+		`Starting server with Config HP="0.0.0.0:3306"|T="28800000"|R="false"|L="debug"`,
+		`{"DisableClientMultiStatements":false,"connectionID":1,"level":"info","msg":"NewConnection","time":"2023-03-24T23:20:49Z"}`,
+		`{"connectTime":"2023-03-24T23:20:49Z","connectionDb":"nautobot","connectionID":1,"level":"debug","msg":"Starting query","query":"SELECT 1","time":"2023-03-24T23:20:49Z"}`,
+		`{"connectTime":"2023-03-24T23:20:49Z","connectionDb":"nautobot","connectionID":1,"level":"debug","msg":"Query finished in 3 ms","query":"SELECT 1","time":"2023-03-24T23:20:49.5Z"}`,
+		`{"connectTime":"2023-03-24T23:20:49Z","connectionDb":"nautobot","connectionID":1,"level":"debug","msg":"Starting query","query":"SELECT * FROM t","time":"2023-03-24T23:20:50Z"}`,
+		`{"connectTime":"2023-03-24T23:20:49Z","connectionDb":"nautobot","connectionID":1,"error":"table not found: t","level":"warning","msg":"error running query","query":"SELECT * FROM t","time":"2023-03-24T23:20:50Z"}`,
+		`{"connectionID":1,"level":"info","msg":"ConnectionClosed","time":"2023-03-24T23:20:51Z"}`,
+	}
+	settings := writeTestLog(t, logs)
+
+	testRun, err := parseTestRun(settings)
+	require.NoError(t, err)
+	queries := testRun.Queries.All
+	require.Len(t, queries, 2)
+	require.Equal(t, 3, queries[0].StartLineNumber)
+	require.Equal(t, 3*time.Millisecond, queries[0].Duration)
+	require.Equal(t, "nautobot", queries[0].ConnectionDb)
+	require.Equal(t, "table not found: t", queries[1].Error)
+	require.Equal(t, "WARN", queries[1].Level)
+	require.Len(t, testRun.Connections, 1)
+	require.True(t, testRun.Connections[0].Closed())
+	require.Empty(t, testRun.PairingIssues)
+}
+
+func TestQueryPairing(t *testing.T) {
+	// prepare
+	logs := []string{
+		"2023-03-24T23:20:49Z INFO [conn 1] NewConnection {DisableClientMultiStatements=false}",
+		"2023-03-24T23:20:49Z INFO [conn 2] NewConnection {DisableClientMultiStatements=false}",
+		"2023-03-24T23:20:49Z DEBUG [conn 1] Starting query {connectTime=2023-03-24T23:20:49Z, connectionDb=nautobot, query=SET NAMES utf8mb4}",
+		"2023-03-24T23:20:49Z DEBUG [conn 2] Starting query {connectTime=2023-03-24T23:20:49Z, connectionDb=nautobot, query=SELECT SLEEP(1000)}",
+		"2023-03-24T23:20:50Z DEBUG [conn 1] Query finished in 22 ms {connectTime=2023-03-24T23:20:49Z, connectionDb=nautobot, query=SET NAMES utf8mb4}",
+		"2023-03-24T23:20:50Z DEBUG [conn 1] Query finished in 1 ms {connectTime=2023-03-24T23:20:49Z, connectionDb=nautobot, query=SET autocommit=0}",
+		"2023-03-24T23:20:51Z INFO [conn 2] ConnectionClosed {}",
+		"2023-03-24T23:20:51Z DEBUG [conn 1] Starting query {connectTime=2023-03-24T23:20:49Z, connectionDb=nautobot, query=SELECT 1}",
+	}
+	settings := writeTestLog(t, logs)
+
+	// Run the main logic
+	result, err := mainLogic(settings)
+	require.NoError(t, err)
+
+	testRun, err := parseTestRun(settings)
+	require.NoError(t, err)
+	require.Len(t, testRun.Queries.All, 2)
+	require.Equal(t, 3, testRun.Queries.All[0].StartLineNumber)
+	require.Equal(t, 22*time.Millisecond, testRun.Queries.All[0].Duration)
+	require.Equal(t, 0, testRun.Queries.All[1].StartLineNumber)
+
+	require.Len(t, testRun.PairingIssues, 3)
+	require.Equal(t, QueryRunningAtConnectionClose, testRun.PairingIssues[0].Kind)
+	require.Equal(t, 4, testRun.PairingIssues[0].Record.LineNumber)
+	require.Equal(t, 7, testRun.PairingIssues[0].ClosedLineNumber)
+	require.Equal(t, QueryFinishedWithoutStart, testRun.PairingIssues[1].Kind)
+	require.Equal(t, 6, testRun.PairingIssues[1].Record.LineNumber)
+	require.Equal(t, QueryNeverFinished, testRun.PairingIssues[2].Kind)
+	require.Equal(t, 8, testRun.PairingIssues[2].Record.LineNumber)
+
+	outBytes, err := os.ReadFile(result.pairingOutputPath)
+	require.NoError(t, err)
+	outText := string(outBytes)
+	require.Contains(t, outText, "Line 4, connection 2: query running when connection closed")
+	require.Contains(t, outText, "SELECT SLEEP(1000)")
+	require.Contains(t, outText, "Line 6, connection 1: query finished without start")
+	require.Contains(t, outText, "Line 8, connection 1: query started but never finished")
+}
+
+func TestConnections(t *testing.T) {
+	// prepare
+	logs := []string{
+		"2023-03-24T23:20:49Z DEBUG [conn 7] Query finished in 3 ms {connectTime=2023-03-24T23:20:40Z, connectionDb=nautobot, query=SELECT 1}",
+		"2023-03-24T23:20:49Z INFO [conn 8] NewConnection {DisableClientMultiStatements=false}",
+		"2023-03-24T23:20:50Z DEBUG [conn 8] Query finished in 5 ms {connectTime=2023-03-24T23:20:49Z, connectionDb=, query=SELECT 2}",
+		"2023-03-24T23:20:51Z WARN [conn 8] error running query {connectTime=2023-03-24T23:20:49Z, connectionDb=test_nautobot, error=table not found: t, query=SELECT * FROM t}",
+		"2023-03-24T23:20:52Z DEBUG [conn 8] Query finished in 7 ms {connectTime=2023-03-24T23:20:49Z, connectionDb=test_nautobot, query=SELECT 3}",
+		"2023-03-24T23:20:59Z INFO [conn 8] ConnectionClosed {}",
+	}
+	settings := writeTestLog(t, logs)
+
+	// Run the main logic
+	result, err := mainLogic(settings)
+	require.NoError(t, err)
+
+	testRun, err := parseTestRun(settings)
+	require.NoError(t, err)
+	require.Len(t, testRun.Connections, 2)
+
+	leaked := testRun.Connections[0]
+	require.Equal(t, 7, leaked.Id)
+	require.Equal(t, 0, leaked.OpenLineNumber)
+	require.False(t, leaked.Closed())
+	require.Len(t, leaked.Queries, 1)
+
+	closed := testRun.Connections[1]
+	require.Equal(t, 8, closed.Id)
+	require.Equal(t, 2, closed.OpenLineNumber)
+	require.Equal(t, 6, closed.CloseLineNumber)
+	require.True(t, closed.Closed())
+	require.Equal(t, "test_nautobot", closed.Database)
+	require.Equal(t, []string{"test_nautobot"}, closed.Databases)
+	require.Len(t, closed.Queries, 3)
+	require.Equal(t, 1, closed.ErrorCount)
+	require.Equal(t, 12*time.Millisecond, closed.TotalQueryTime)
+
+	outBytes, err := os.ReadFile(result.connectionsOutputPath)
+	require.NoError(t, err)
+	outText := string(outBytes)
+	require.Contains(t, outText, "Connection 7\nOpened: before the start of the log\nClosed: never\n")
+	require.Contains(t, outText, "Lifetime: 10s\n")
+	require.Contains(t, outText, "Line 4, error: table not found: t\nSELECT * FROM t;\n")
+
+	// queries hidden from the analysis because they aren't part of a test still count for their connection
+	settings.hideNonTestQueries = true
+	testRun, err = parseTestRun(settings)
+	require.NoError(t, err)
+	require.Empty(t, testRun.Queries.All)
+	require.Len(t, testRun.Connections[1].Queries, 3)
+	require.Equal(t, 12*time.Millisecond, testRun.Connections[1].TotalQueryTime)
+}
+
+func TestSessionState(t *testing.T) {
+	// prepare
+	logs := []string{
+		"2023-03-24T23:20:49Z DEBUG [conn 1] Query finished in 1 ms {connectTime=2023-03-24T23:20:49Z, connectionDb=nautobot, query=SET NAMES utf8mb4}",
+		"2023-03-24T23:20:49Z DEBUG [conn 1] Query finished in 1 ms {connectTime=2023-03-24T23:20:49Z, connectionDb=nautobot, query=SET autocommit=0}",
+		"2023-03-24T23:20:49Z DEBUG [conn 1] Query finished in 1 ms {connectTime=2023-03-24T23:20:49Z, connectionDb=nautobot, query=SET GLOBAL max_connections=10}",
+		"2023-03-24T23:20:49Z WARN [conn 1] error running query {connectTime=2023-03-24T23:20:49Z, connectionDb=nautobot, error=database not found: missing, query=USE missing}",
+		"2023-03-24T23:20:49Z DEBUG [conn 1] Query finished in 1 ms {connectTime=2023-03-24T23:20:49Z, connectionDb=nautobot, query=USE test_nautobot}",
+		"2023-03-24T23:20:49Z DEBUG [conn 1] Query finished in 1 ms {connectTime=2023-03-24T23:20:49Z, connectionDb=test_nautobot, query=SET SESSION sql_mode='STRICT_TRANS_TABLES', @x = 5}",
+		"2023-03-24T23:20:49Z DEBUG [conn 1] Query finished in 1 ms {connectTime=2023-03-24T23:20:49Z, connectionDb=test_nautobot, query=SELECT 1}",
+		"2023-03-24T23:20:49Z DEBUG [conn 2] Query finished in 1 ms {connectTime=2023-03-24T23:20:49Z, connectionDb=nautobot, query=SELECT 2}",
+		"2023-03-24T23:20:49Z DEBUG [conn 1] Query finished in 1 ms {connectTime=2023-03-24T23:20:49Z, connectionDb=test_nautobot, query=SET sql_mode=DEFAULT, autocommit=ON}",
+		"2023-03-24T23:20:49Z DEBUG [conn 1] Query finished in 1 ms {connectTime=2023-03-24T23:20:49Z, connectionDb=test_nautobot, query=SELECT 3}",
+	}
+	settings := writeTestLog(t, logs)
+
+	// Run the main logic
+	result, err := mainLogic(settings)
+	require.NoError(t, err)
+
+	testRun, err := parseTestRun(settings)
+	require.NoError(t, err)
+	queries := testRun.Queries.All
+	require.Len(t, queries, 10)
+
+	// each query carries the state it ran under
+	require.Equal(t, "database=nautobot", queries[0].Session.String())
+	require.True(t, queries[1].Session.Autocommit())
+	require.False(t, queries[2].Session.Autocommit())
+	require.Equal(t, queries[2].Session, queries[4].Session)
+
+	selectOne := queries[6]
+	require.Equal(t, "test_nautobot", selectOne.Session.Database)
+	require.False(t, selectOne.Session.Autocommit())
+	require.Equal(t, "STRICT_TRANS_TABLES", selectOne.Session.SqlMode())
+	require.Equal(t, "database=test_nautobot, autocommit=0, character_set_client=utf8mb4, character_set_connection=utf8mb4, "+
+		"character_set_results=utf8mb4, sql_mode=STRICT_TRANS_TABLES, @x=5", selectOne.Session.String())
+
+	// sessions are per connection
+	require.Equal(t, "database=nautobot", queries[7].Session.String())
+
+	selectThree := queries[9]
+	require.True(t, selectThree.Session.Autocommit())
+	require.Equal(t, "", selectThree.Session.SqlMode())
+
+	outBytes, err := os.ReadFile(result.queriesOutputPath)
+	require.NoError(t, err)
+	require.Contains(t, string(outBytes), "Session: database=test_nautobot, autocommit=0, character_set_client=utf8mb4")
+}
+
+func TestTransactions(t *testing.T) {
+	// prepare
+	queries := []string{
+		"SET autocommit=0",
+		"SAVEPOINT `s1_x1`",
+		"INSERT INTO t VALUES (1)",
+		"SAVEPOINT `s1_x2`",
+		"ROLLBACK TO SAVEPOINT `s1_x2`",
+		"RELEASE SAVEPOINT `s1_x2`",
+		"RELEASE SAVEPOINT `s1_x2`",
+		"ROLLBACK TO SAVEPOINT `nope`",
+		"COMMIT",
+		"SET autocommit=1",
+		"COMMIT",
+		"BEGIN",
+		"SELECT 1",
+	}
+	logs := []string{}
+	for _, query := range queries {
+		logs = append(logs, "2023-03-24T23:20:49Z DEBUG [conn 1] Query finished in 1 ms {connectTime=2023-03-24T23:20:49Z, connectionDb=nautobot, query="+query+"}")
+	}
+	logs = append(logs,
+		"2023-03-24T23:20:49Z DEBUG [conn 2] Query finished in 1 ms {connectTime=2023-03-24T23:20:49Z, connectionDb=nautobot, query=SELECT 2}",
+		"2023-03-24T23:20:50Z INFO [conn 1] ConnectionClosed {}",
+	)
+	settings := writeTestLog(t, logs)
+
+	// Run the main logic
+	result, err := mainLogic(settings)
+	require.NoError(t, err)
+
+	testRun, err := parseTestRun(settings)
+	require.NoError(t, err)
+
+	transactionIds := []int{}
+	for _, query := range testRun.Queries.All {
+		transactionIds = append(transactionIds, query.TransactionId)
+	}
+	require.Equal(t, []int{0, 1, 1, 1, 1, 1, 1, 1, 1, 0, 0, 2, 2, 0}, transactionIds)
+
+	require.Len(t, testRun.Transactions, 2)
+	committed := testRun.Transactions[0]
+	require.False(t, committed.Explicit)
+	require.Equal(t, TransactionCommitted, committed.Outcome)
+	require.Equal(t, 2, committed.StartLineNumber)
+	require.Equal(t, 9, committed.EndLineNumber)
+	require.Len(t, committed.Savepoints, 1)
+	outer := committed.Savepoints[0]
+	require.Equal(t, "s1_x1", outer.Name)
+	require.Equal(t, 9, outer.ReleasedLineNumber)
+	require.Len(t, outer.Children, 1)
+	inner := outer.Children[0]
+	require.Equal(t, "s1_x2", inner.Name)
+	require.Equal(t, []int{5}, inner.RollbackLineNumbers)
+	require.Equal(t, 6, inner.ReleasedLineNumber)
+
+	depths := []int{}
+	for _, statement := range committed.Statements {
+		depths = append(depths, statement.Depth)
+	}
+	require.Equal(t, []int{0, 1, 1, 2, 1, 1, 1, 0}, depths)
+
+	abandoned := testRun.Transactions[1]
+	require.True(t, abandoned.Explicit)
+	require.Equal(t, TransactionAbandoned, abandoned.Outcome)
+	require.Equal(t, 15, abandoned.EndLineNumber)
+
+	anomalies := []string{}
+	for _, anomaly := range testRun.TransactionAnomalies {
+		anomalies = append(anomalies, anomaly.String(nil))
+	}
+	require.Equal(t, []string{
+		"Line 7, connection 1: release of already released savepoint s1_x2 (transaction 1)",
+		"Line 8, connection 1: rollback to unknown savepoint nope (transaction 1)",
+		"Line 11, connection 1: commit with autocommit on",
+		"Line 15, connection 1: transaction open when connection closed (transaction 2)",
+	}, anomalies)
+
+	outBytes, err := os.ReadFile(result.transactionsOutputPath)
+	require.NoError(t, err)
+	outText := string(outBytes)
+	require.Contains(t, outText, "Transaction 1, connection 1\nStarted: line 2, autocommit off\nOutcome: committed on line 9\n")
+	require.Contains(t, outText, "    s1_x2, created on line 4, rolled back to on line 5, released on line 6\n")
+	require.Contains(t, outText, "      Line 5: ROLLBACK TO SAVEPOINT `s1_x2`\n")
+
+	// hidden queries are still statements of their transaction
+	settings.hideNonTestQueries = true
+	testRun, err = parseTestRun(settings)
+	require.NoError(t, err)
+	require.Empty(t, testRun.Queries.All)
+	require.Len(t, testRun.Transactions[0].Statements, len(committed.Statements))
+}
+
+func TestDurationStats(t *testing.T) {
+	queries := []Query{}
+	for i := 1; i <= 100; i++ {
+		queries = append(queries, Query{Duration: time.Duration(i) * time.Millisecond})
+	}
+	queries = append(queries, Query{Error: "table not found: t"})
+
+	stats := NewDurationStats(queries)
+	require.Equal(t, 100, stats.Count)
+	require.Equal(t, 5050*time.Millisecond, stats.Total)
+	require.Equal(t, 1*time.Millisecond, stats.Min)
+	require.Equal(t, 50500*time.Microsecond, stats.Mean)
+	require.Equal(t, 50*time.Millisecond, stats.P50)
+	require.Equal(t, 95*time.Millisecond, stats.P95)
+	require.Equal(t, 99*time.Millisecond, stats.P99)
+	require.Equal(t, 100*time.Millisecond, stats.Max)
+
+	require.Equal(t, DurationStats{}, NewDurationStats(nil))
+
+	groups := map[string][]Query{
+		"fast": {{Duration: time.Millisecond}, {Duration: time.Millisecond}},
+		"slow": {{Duration: 10 * time.Millisecond}},
+	}
+	shapes := slowestShapes(groups, 1)
+	require.Len(t, shapes, 1)
+	require.Equal(t, "slow", shapes[0].First)
+
+	slowest := slowestQueries(queries, 2)
+	require.Equal(t, 100*time.Millisecond, slowest[0].Duration)
+	require.Equal(t, 99*time.Millisecond, slowest[1].Duration)
+}
+
+func TestParseFailures(t *testing.T) {
+	// prepare
+	logs := []string{
+		"2023-03-24T23:20:49Z DEBUG [conn 1] Query finished in 1 ms {connectTime=2023-03-24T23:20:49Z, connectionDb=nautobot, query=select 'dolt: setUp, test id = app.tests.SomeTestCase.test_one'}",
+		"2023-03-24T23:20:49Z DEBUG [conn 1] Query finished in 1 ms {connectTime=2023-03-24T23:20:49Z, connectionDb=nautobot, query=SELECT 1}",
+		"2023-03-24T23:20:49Z DEBUG [conn 1] Query finished in 2 ms {connectTime=2023-03-24T23:20:49Z, connectionDb=nautobot, query=FROBNICATE t}",
+		"2023-03-24T23:20:49Z WARN [conn 1] error running query {connectTime=2023-03-24T23:20:49Z, connectionDb=nautobot, error=syntax error, query=FROBNICATE u}",
+		"2023-03-24T23:20:49Z DEBUG [conn 1] Query finished in 1 ms {connectTime=2023-03-24T23:20:49Z, connectionDb=nautobot, query=select 'dolt: _post_teardown, test id = app.tests.SomeTestCase.test_one'}",
+	}
+	settings := writeTestLog(t, logs)
+
+	// Run the main logic
+	result, err := mainLogic(settings)
+	require.NoError(t, err)
+
+	testRun, err := parseTestRun(settings)
+	require.NoError(t, err)
+	queries := testRun.Queries.All
+	require.Len(t, queries, 5)
+	require.Len(t, testRun.Queries.ParseFailures, 2)
+	require.Nil(t, queries[2].Node)
+	require.Contains(t, queries[2].ParseError, "syntax error")
+	require.Equal(t, 2*time.Millisecond, queries[2].Duration)
+	require.Len(t, testRun.Tests, 1)
+	require.Len(t, testRun.Tests[0].Queries, 4)
+
+	outBytes, err := os.ReadFile(result.queriesOutputPath)
+	require.NoError(t, err)
+	require.Contains(t, string(outBytes), "Parse error: syntax error at position 11 near 'FROBNICATE'")
+
+	outBytes, err = os.ReadFile(result.testsOutputPath)
+	require.NoError(t, err)
+	require.Contains(t, string(outBytes), "-- parse error: syntax error at position 11 near 'FROBNICATE'\nFROBNICATE t;\n")
+
+	outBytes, err = os.ReadFile(result.analysisOutputPath)
+	require.NoError(t, err)
+	outText := string(outBytes)
+	require.Contains(t, outText, "Parse failures: 2\n")
+	require.Contains(t, outText, "Parse error: syntax error at position 11 near 'FROBNICATE'\nNumber of queries: 2\n"+
+		"Line 3, test app.tests.SomeTestCase.test_one\nFROBNICATE t;\nLine 4, test app.tests.SomeTestCase.test_one\nFROBNICATE u;\n")
+}
+
+func TestLineReader(t *testing.T) {
+	longLine := strings.Repeat("x", 5*1024*1024)
+	input := "first\nsecond\r\n" + longLine + "\n\nlast"
+	reader := NewLineReader(strings.NewReader(input), defaultMaxLineLength)
+	lines := []string{}
+	for reader.Next() {
+		require.False(t, reader.TooLong())
+		lines = append(lines, reader.Text())
+	}
+	require.NoError(t, reader.Err())
+	require.Equal(t, []string{"first", "second", longLine, "", "last"}, lines)
+
+	// lines longer than the maximum are skipped, the ones after them are still read
+	reader = NewLineReader(strings.NewReader(longLine+"\r\n"+longLine[:1024]+"\r\nafter\n"), 1024)
+	require.True(t, reader.Next())
+	require.True(t, reader.TooLong())
+	require.Equal(t, "", reader.Text())
+	require.True(t, reader.Next())
+	require.False(t, reader.TooLong())
+	require.Equal(t, longLine[:1024], reader.Text())
+	require.True(t, reader.Next())
+	require.Equal(t, "after", reader.Text())
+	require.False(t, reader.Next())
+	require.NoError(t, reader.Err())
+
+	// I/O errors stop the reader and are reported
+	readErr := errors.New("disk on fire")
+	reader = NewLineReader(io.MultiReader(strings.NewReader("first\nsec"), iotest.ErrReader(readErr)), defaultMaxLineLength)
+	require.True(t, reader.Next())
+	require.Equal(t, "first", reader.Text())
+	require.False(t, reader.Next())
+	require.ErrorIs(t, reader.Err(), readErr)
+}
+
+func TestLongLogLines(t *testing.T) {
+	// prepare
+	values := make([]string, 0)
+	for i := 0; i < 150_000; i++ {
+		values = append(values, fmt.Sprintf("(%d, 'name %d')", i, i))
+	}
+	// about 3 MiB, and 4 MiB once it's base64-encoded
+	insert := "INSERT INTO t VALUES " + strings.Join(values, ", ")
+	logs := []string{
+		"2023-03-24T23:20:49Z DEBUG [conn 1] Query finished in 900 ms {connectTime=2023-03-24T23:20:49Z, connectionDb=nautobot, query=" + base64.StdEncoding.EncodeToString([]byte(insert)) + "}",
+		"2023-03-24T23:20:49Z DEBUG [conn 1] Query finished in 1 ms {connectTime=2023-03-24T23:20:49Z, connectionDb=nautobot, query=U0VMRUNUIDE=}",
+	}
+	settings := writeTestLog(t, logs)
+	settings.logFormat = "base64"
+
+	testRun, err := parseTestRun(settings)
+	require.NoError(t, err)
+	require.Len(t, testRun.Queries.All, 2)
+	require.Equal(t, insert, testRun.Queries.All[0].Text)
+	require.Equal(t, "insert into t values (?, ?)", testRun.Queries.All[0].Fingerprint.Text)
+	require.Empty(t, testRun.SkippedLines)
+
+	// with a lower limit, the long line is reported and the rest of the log is still analyzed
+	settings.maxLineLength = 1024 * 1024
+	result, err := mainLogic(settings)
+	require.NoError(t, err)
+	testRun, err = parseTestRun(settings)
+	require.NoError(t, err)
+	require.Len(t, testRun.Queries.All, 1)
+	require.Equal(t, "SELECT 1", testRun.Queries.All[0].Text)
+	require.Equal(t, []int{1}, testRun.SkippedLines)
+
+	outBytes, err := os.ReadFile(result.analysisOutputPath)
+	require.NoError(t, err)
+	require.Contains(t, string(outBytes), "Lines too long to analyze: 1\n")
+}
+
+func TestTestMarkers(t *testing.T) {
+	line := func(query string) string {
+		return "2023-03-24T23:20:49Z DEBUG [conn 1] Query finished in 1 ms {connectTime=2023-03-24T23:20:49Z, connectionDb=nautobot, query=" + query + "}"
+	}
+	scopes := func(testRun TestRun) []string {
+		result := []string{}
+		for _, query := range testRun.Queries.All {
+			result = append(result, fmt.Sprintf("%s|%s|%s", query.TestId, query.TestClassId, query.TestPhase))
+		}
+		return result
+	}
+
+	// comment tags, with class scopes around the tests
+	settings := writeTestLog(t, []string{
+		line("SELECT 1"),
+		line("/* test:class-setup app.tests.SomeTestCase */"),
+		line("CREATE TABLE t (i int)"),
+		line("/* test:setup app.tests.SomeTestCase.test_one */ SELECT 2"),
+		line("/* test:call app.tests.SomeTestCase.test_one */"),
+		line("SELECT * FROM t"),
+		line("/* test:teardown app.tests.SomeTestCase.test_one */"),
+		line("/* test:end app.tests.SomeTestCase.test_one */"),
+		line("/* test:class-teardown app.tests.SomeTestCase */"),
+		line("DROP TABLE t"),
+		line("/* test:class-end app.tests.SomeTestCase */"),
+		line("SELECT 3"),
+	})
+	settings.testMarkers = []string{"comment"}
+	result, err := mainLogic(settings)
+	require.NoError(t, err)
+	testRun, err := parseTestRun(settings)
+	require.NoError(t, err)
+	require.Equal(t, []string{
+		"||",
+		"app.tests.SomeTestCase|app.tests.SomeTestCase|class-setup",
+		"app.tests.SomeTestCase|app.tests.SomeTestCase|class-setup",
+		"app.tests.SomeTestCase.test_one|app.tests.SomeTestCase|setup",
+		"app.tests.SomeTestCase.test_one|app.tests.SomeTestCase|call",
+		"app.tests.SomeTestCase.test_one|app.tests.SomeTestCase|call",
+		"app.tests.SomeTestCase.test_one|app.tests.SomeTestCase|teardown",
+		"app.tests.SomeTestCase|app.tests.SomeTestCase|class-setup",
+		"app.tests.SomeTestCase|app.tests.SomeTestCase|class-teardown",
+		"app.tests.SomeTestCase|app.tests.SomeTestCase|class-teardown",
+		"||",
+		"||",
+	}, scopes(testRun))
+	require.Len(t, testRun.Tests, 2)
+	require.Len(t, testRun.Tests[0].Queries, 5) // the class setup and teardown
+	require.Equal(t, "app.tests.SomeTestCase.test_one", testRun.Tests[1].Id)
+	require.Equal(t, "app.tests.SomeTestCase", testRun.Tests[1].ClassId)
+
+	outBytes, err := os.ReadFile(result.testsOutputPath)
+	require.NoError(t, err)
+	require.Contains(t, string(outBytes), "Class: app.tests.SomeTestCase\n")
+	require.Contains(t, string(outBytes), "-- phase: call\n/* test:call app.tests.SomeTestCase.test_one */;\nSELECT * FROM t;\n-- phase: teardown\n")
+	outBytes, err = os.ReadFile(result.queriesOutputPath)
+	require.NoError(t, err)
+	require.Contains(t, string(outBytes), "Test phase: call\n")
+
+	// session variables
+	settings = writeTestLog(t, []string{
+		line("SET @test_class = 'SomeTestCase'"),
+		line("SET @test_id = 'test_one'"),
+		line("SELECT 1"),
+		line("SET @test_phase = 'teardown'"),
+		line("SET @test_id = 'test_two'"),
+		line("SET @test_id = NULL"),
+		line("SET @test_class = NULL"),
+	})
+	settings.testMarkers = []string{"variable"}
+	testRun, err = parseTestRun(settings)
+	require.NoError(t, err)
+	require.Equal(t, []string{
+		"SomeTestCase|SomeTestCase|class-setup",
+		"test_one|SomeTestCase|setup",
+		"test_one|SomeTestCase|setup",
+		"test_one|SomeTestCase|teardown",
+		"test_two|SomeTestCase|setup",
+		"SomeTestCase|SomeTestCase|class-setup",
+		"||",
+	}, scopes(testRun))
+
+	// markers from a file, combined with a preset
+	markersPath := filepath.Join(t.TempDir(), "markers.json")
+	require.NoError(t, os.WriteFile(markersPath, []byte(`[
+		{"action": "test-start", "pattern": "^-- begin (\\S+)", "phase": "call"},
+		{"action": "test-end", "pattern": "^-- done (?P<id>\\S+)"}
+	]`), 0644))
+	settings = writeTestLog(t, []string{
+		line("-- begin test_one"),
+		line("SELECT 1"),
+		line("-- done test_one"),
+		line("select 'dolt: setUp, test id = app.tests.SomeTestCase.test_two'"),
+		line("select 'dolt: _post_teardown, test id = app.tests.SomeTestCase.test_two'"),
+	})
+	settings.testMarkers = []string{markersPath, "nautobot"}
+	testRun, err = parseTestRun(settings)
+	require.NoError(t, err)
+	require.Equal(t, []string{"test_one||call", "test_one||call", "||", "app.tests.SomeTestCase.test_two||", "||"}, scopes(testRun))
+
+	// invalid markers
+	_, err = loadTestMarkers([]string{"pytest"})
+	require.Error(t, err)
+	require.NoError(t, os.WriteFile(markersPath, []byte(`[{"action": "test-begin", "pattern": "x"}]`), 0644))
+	_, err = loadTestMarkers([]string{markersPath})
+	require.ErrorContains(t, err, "unknown test marker action")
+	require.NoError(t, os.WriteFile(markersPath, []byte(`[{"action": "phase", "pattern": "x"}]`), 0644))
+	_, err = loadTestMarkers([]string{markersPath})
+	require.ErrorContains(t, err, "needs a phase")
+}
+
+func TestParallelTestAttribution(t *testing.T) {
+	line := func(connection int, query string) string {
+		return fmt.Sprintf("2023-03-24T23:20:49Z DEBUG [conn %d] Query finished in 1 ms {connectTime=2023-03-24T23:20:49Z, connectionDb=nautobot, query=%s}", connection, query)
+	}
+	start := func(connection int, testId string) string {
+		return line(connection, "select 'dolt: setUp, test id = "+testId+"'")
+	}
+	finish := func(connection int, testId string) string {
+		return line(connection, "select 'dolt: _post_teardown, test id = "+testId+"'")
+	}
+	testIds := func(testRun TestRun) []string {
+		result := []string{}
+		for _, query := range testRun.Queries.All {
+			result = append(result, query.TestId)
+		}
+		return result
+	}
+
+	// two test runner connections interleave their tests, conn 3 never sends a marker
+	settings := writeTestLog(t, []string{
+		start(1, "app.tests.A.test_a"),
+		line(3, "SELECT 0"),
+		start(2, "app.tests.B.test_b"),
+		line(1, "SELECT 1"),
+		line(2, "SELECT 2"),
+		line(3, "SELECT 3"),
+		finish(1, "app.tests.A.test_a"),
+		line(3, "SELECT 4"),
+		line(2, "SELECT 5"),
+		finish(2, "app.tests.B.test_b"),
+		line(3, "SELECT 6"),
+	})
+	testRun, err := parseTestRun(settings)
+	require.NoError(t, err)
+	require.Equal(t, []string{
+		"app.tests.A.test_a",
+		"app.tests.A.test_a",
+		"app.tests.B.test_b",
+		"app.tests.A.test_a",
+		"app.tests.B.test_b",
+		"",
+		"",
+		"app.tests.B.test_b",
+		"app.tests.B.test_b",
+		"",
+		"",
+	}, testIds(testRun))
+	require.Len(t, testRun.Tests, 2)
+	require.Equal(t, "app.tests.A.test_a", testRun.Tests[0].Id)
+	require.Len(t, testRun.Tests[0].Queries, 3)
+	require.Equal(t, "app.tests.B.test_b", testRun.Tests[1].Id)
+	require.Len(t, testRun.Tests[1].Queries, 4)
+
+	settings.unmarkedPolicy = UnmarkedLatest
+	testRun, err = parseTestRun(settings)
+	require.NoError(t, err)
+	unmarked := []string{}
+	for _, query := range testRun.Queries.All {
+		if query.ConnectionId == 3 {
+			unmarked = append(unmarked, query.TestId)
+		}
+	}
+	require.Equal(t, []string{"app.tests.A.test_a", "app.tests.B.test_b", "app.tests.B.test_b", ""}, unmarked)
+
+	settings.unmarkedPolicy = UnmarkedNone
+	testRun, err = parseTestRun(settings)
+	require.NoError(t, err)
+	require.Equal(t, "", testRun.Queries.All[1].TestId)
+
+	// a worker's markers tell its tests apart from other workers', on whatever connection it sends them
+	settings = writeTestLog(t, []string{
+		line(4, "/* test:setup app.tests.A.test_a worker=gw0 */"),
+		line(5, "/* test:setup app.tests.B.test_b worker=gw1 */"),
+		"2023-03-24T23:20:49Z INFO [conn 4] ConnectionClosed {}",
+		line(6, "/* test:call app.tests.A.test_a worker=gw0 */"),
+		line(6, "SELECT 1"),
+		line(5, "SELECT 2"),
+		line(6, "/* test:end app.tests.A.test_a worker=gw0 */"),
+	})
+	settings.testMarkers = []string{"comment"}
+	testRun, err = parseTestRun(settings)
+	require.NoError(t, err)
+	require.Equal(t, []string{
+		"app.tests.A.test_a",
+		"app.tests.B.test_b",
+		"app.tests.A.test_a",
+		"app.tests.A.test_a",
+		"app.tests.B.test_b",
+		"",
+	}, testIds(testRun))
+	require.Equal(t, PhaseCall, testRun.Queries.All[3].TestPhase)
+	_, err = parseUnmarkedConnectionPolicy("all")
+	require.Error(t, err)
+}
+
+func TestTestResults(t *testing.T) {
+	line := func(query string) string {
+		return "2023-03-24T23:20:49Z DEBUG [conn 1] Query finished in 1 ms {connectTime=2023-03-24T23:20:49Z, connectionDb=nautobot, query=" + query + "}"
+	}
+	marked := func(testId string) []string {
+		return []string{
+			line(fmt.Sprintf("select 'dolt: setUp, test id = %s'", testId)),
+			line("SELECT 1"),
+			line(fmt.Sprintf("select 'dolt: _post_teardown, test id = %s'", testId)),
+		}
+	}
+	logs := append(marked("app.tests.SomeTestCase.test_ok"), marked("app.tests.SomeTestCase.test_fail")...)
+	logs = append(logs, marked("app.tests.SomeTestCase.test_docstring")...)
+	logs = append(logs, marked("app.tests.SomeTestCase.test_not_reported")...)
+	settings := writeTestLog(t, logs)
+
+	settings.pytestReportPath = filepath.Join(t.TempDir(), "report.txt")
+	require.NoError(t, os.WriteFile(settings.pytestReportPath, []byte(`Found 7 test(s).
+test_ok (app.tests.SomeTestCase) ... ok
+test_fail (app.tests.SomeTestCase.test_fail) ... FAIL
+test_docstring (app.tests.SomeTestCase)
+Verify the docstring. ... ok (0.250s)
+test_output (app.tests.SomeTestCase) ... some output of the test
+ERROR
+test_skipped (app.tests.SomeTestCase) ... skipped 'not on dolt'
+test_expected (app.tests.SomeTestCase) ... expected failure
+test_unexpected (app.tests.SomeTestCase) ... unexpected success
+
+Slowest test durations
+----------------------------------------------------------------------
+1.500s test_ok (app.tests.SomeTestCase)
+
+======================================================================
+FAIL: test_fail (app.tests.SomeTestCase.test_fail)
+----------------------------------------------------------------------
+Traceback (most recent call last):
+  File "/source/app/tests.py", line 12, in test_fail
+    self.assertEqual([1], [2])
+AssertionError: Lists differ: [1] != [2]
+
+First differing element 0:
+1
+2
+
+======================================================================
+ERROR: test_output (app.tests.SomeTestCase)
+----------------------------------------------------------------------
+Traceback (most recent call last):
+  File "/source/app/tests.py", line 20, in test_output
+    self.lookup()
+KeyError: 'name'
+
+During handling of the above exception, another exception occurred:
+
+Traceback (most recent call last):
+  File "/source/app/tests.py", line 22, in test_output
+    raise ValueError("no name")
+    ^^^^^^^^^^^^^^^^^^^^^^^^^^^
+  File "/source/app/models.py", line 5, in lookup
+ValueError: no name
+
+----------------------------------------------------------------------
+Ran 7 tests in 2.000s
+
+FAILED (failures=1, errors=1, skipped=1, expected failures=1, unexpected successes=1)
+`), 0644))
+
+	testRun, err := parseTestRun(settings)
+	require.NoError(t, err)
+	results := []string{}
+	for _, result := range testRun.TestResults {
+		results = append(results, fmt.Sprintf("%d %s %s %s %s %s", result.Order, result.Id, result.Status, result.Reason,
+			result.Docstring, result.Duration))
+	}
+	require.Equal(t, []string{
+		"1 app.tests.SomeTestCase.test_ok ok   1.5s",
+		"2 app.tests.SomeTestCase.test_fail FAIL   0s",
+		"3 app.tests.SomeTestCase.test_docstring ok  Verify the docstring. 250ms",
+		"4 app.tests.SomeTestCase.test_output ERROR   0s",
+		"5 app.tests.SomeTestCase.test_skipped skipped not on dolt  0s",
+		"6 app.tests.SomeTestCase.test_expected expected failure   0s",
+		"7 app.tests.SomeTestCase.test_unexpected unexpected success   0s",
+	}, results)
+	require.Equal(t, []string{
+		"app.tests.SomeTestCase.test_fail",
+		"app.tests.SomeTestCase.test_output",
+		"app.tests.SomeTestCase.test_unexpected",
+	}, testRun.FailedTestIds)
+	require.Equal(t, TestFailed, testRun.Tests[1].Result.Status)
+
+	require.Len(t, testRun.TestFailures, 2)
+	failure := testRun.TestFailures[0]
+	require.Equal(t, "app.tests.SomeTestCase.test_fail", failure.Id)
+	require.Equal(t, TestFailed, failure.Status)
+	require.Equal(t, []TracebackFrame{{File: "/source/app/tests.py", Line: 12, Function: "test_fail", Code: "self.assertEqual([1], [2])"}}, failure.Frames)
+	require.Equal(t, "AssertionError: Lists differ: [1] != [2]\n\nFirst differing element 0:\n1\n2", failure.Exception)
+	require.Equal(t, "AssertionError: Lists differ: [1] != [2] (tests.py:12, in test_fail)", failure.Summary())
+	failure = testRun.TestFailures[1]
+	require.Equal(t, "app.tests.SomeTestCase.test_output", failure.Id)
+	require.Equal(t, TestErrored, failure.Status)
+	require.Equal(t, []TracebackFrame{
+		{File: "/source/app/tests.py", Line: 22, Function: "test_output", Code: `raise ValueError("no name")`},
+		{File: "/source/app/models.py", Line: 5, Function: "lookup"},
+	}, failure.Frames)
+	require.Equal(t, "ValueError: no name", failure.Exception)
+	require.Equal(t, &testRun.TestFailures[0], testRun.Tests[1].Failure)
+	require.Nil(t, testRun.Tests[0].Failure)
+	require.Nil(t, testRun.Tests[3].Result)
+
+	unmarked, unreported := compareTestResults(testRun.TestResults, testRun.Tests)
+	unmarkedIds := []string{}
+	for _, result := range unmarked {
+		unmarkedIds = append(unmarkedIds, result.Id)
+	}
+	require.Equal(t, []string{
+		"app.tests.SomeTestCase.test_output",
+		"app.tests.SomeTestCase.test_expected",
+		"app.tests.SomeTestCase.test_unexpected",
+	}, unmarkedIds)
+	require.Len(t, unreported, 1)
+	require.Equal(t, "app.tests.SomeTestCase.test_not_reported", unreported[0].Id)
+
+	result, err := mainLogic(settings)
+	require.NoError(t, err)
+	outBytes, err := os.ReadFile(result.analysisOutputPath)
+	require.NoError(t, err)
+	require.Contains(t, string(outBytes), "Test results: 7 (ok 2, FAIL 1, ERROR 1, skipped 1, expected failure 1, unexpected success 1)\n")
+	require.Contains(t, string(outBytes), "Tests without marker queries: 3\n")
+	require.Contains(t, string(outBytes), "Marked tests missing from the report: 1\n")
+	require.Contains(t, string(outBytes), "Instrumentation gaps:\n")
+	outBytes, err = os.ReadFile(result.testsOutputPath)
+	require.NoError(t, err)
+	require.Contains(t, string(outBytes), "FAIL: report line 17\nTraceback (most recent call last):\n"+
+		"  File \"/source/app/tests.py\", line 12, in test_fail\n    self.assertEqual([1], [2])\nAssertionError: Lists differ")
+	outBytes, err = os.ReadFile(result.queriesOutputPath)
+	require.NoError(t, err)
+	require.Contains(t, string(outBytes), "Test failure: AssertionError: Lists differ: [1] != [2] (tests.py:12, in test_fail)\n")
+}
+
+func TestJUnitReport(t *testing.T) {
+	line := func(query string) string {
+		return "2023-03-24T23:20:49Z DEBUG [conn 1] Query finished in 1 ms {connectTime=2023-03-24T23:20:49Z, connectionDb=nautobot, query=" + query + "}"
+	}
+	settings := writeTestLog(t, []string{
+		line("select 'dolt: setUp, test id = app.tests.SomeTestCase.test_ok'"),
+		line("select 'dolt: setUp, test id = app.tests.SomeTestCase.test_fail'"),
+		line("SELECT 1"),
+		line("select 'dolt: _post_teardown, test id = app.tests.SomeTestCase.test_fail'"),
+	})
+	settings.junitReportPaths = []string{filepath.Join(t.TempDir(), "junit.xml")}
+	require.NoError(t, os.WriteFile(settings.junitReportPaths[0], []byte(`<?xml version="1.0" encoding="utf-8"?>
+<testsuites>
+  <testsuite name="pytest" tests="6">
+    <testcase classname="app.tests.SomeTestCase" name="test_ok" time="0.012"/>
+    <testcase classname="app.tests.SomeTestCase" name="test_fail" time="1.5">
+      <failure type="AssertionError" message="0 != 2">Traceback (most recent call last):
+  File "/source/app/tests.py", line 12, in test_fail
+    self.assertEqual(0, 2)
+AssertionError: 0 != 2</failure>
+    </testcase>
+    <testsuite name="nested">
+      <testcase classname="tests.test_views" name="test_view" time="0.1">
+        <error message="ValueError: no view">def test_view():
+&gt;       raise ValueError("no view")
+E       ValueError: no view</error>
+      </testcase>
+    </testsuite>
+    <testcase classname="app.tests.SomeTestCase" name="test_skipped"><skipped type="pytest.skip" message="not on dolt"/></testcase>
+    <testcase classname="app.tests.SomeTestCase" name="test_xfail"><skipped type="pytest.xfail" message="known bug"/></testcase>
+  </testsuite>
+</testsuites>
+`), 0644))
+
+	testRun, err := parseTestRun(settings)
+	require.NoError(t, err)
+	results := []string{}
+	for _, result := range testRun.TestResults {
+		results = append(results, fmt.Sprintf("%d %s %s %s %s", result.Order, result.Id, result.Status, result.Reason, result.Duration))
+	}
+	require.Equal(t, []string{
+		"1 app.tests.SomeTestCase.test_ok ok  12ms",
+		"2 app.tests.SomeTestCase.test_fail FAIL  1.5s",
+		"3 tests.test_views.test_view ERROR  100ms",
+		"4 app.tests.SomeTestCase.test_skipped skipped not on dolt 0s",
+		"5 app.tests.SomeTestCase.test_xfail expected failure known bug 0s",
+	}, results)
+	require.Equal(t, []string{"app.tests.SomeTestCase.test_fail", "tests.test_views.test_view"}, testRun.FailedTestIds)
+
+	require.Len(t, testRun.TestFailures, 2)
+	require.Equal(t, []TracebackFrame{{File: "/source/app/tests.py", Line: 12, Function: "test_fail", Code: "self.assertEqual(0, 2)"}},
+		testRun.TestFailures[0].Frames)
+	require.Equal(t, "AssertionError: 0 != 2", testRun.TestFailures[0].Exception)
+	require.Equal(t, 5, testRun.TestFailures[0].LineNumber)
+	require.Empty(t, testRun.TestFailures[1].Frames)
+	require.Equal(t, "ValueError: no view", testRun.TestFailures[1].Exception)
+
+	require.Len(t, testRun.Tests, 2)
+	require.True(t, testRun.Tests[1].Failed)
+	require.Equal(t, "AssertionError: 0 != 2 (tests.py:12, in test_fail)", testRun.Tests[1].Failure.Summary())
+	unmarked, unreported := compareTestResults(testRun.TestResults, testRun.Tests)
+	require.Len(t, unmarked, 2)
+	require.Empty(t, unreported)
+
+	// tests that the pytest report has keep its result
+	settings.pytestReportPath = filepath.Join(t.TempDir(), "report.txt")
+	require.NoError(t, os.WriteFile(settings.pytestReportPath, []byte("test_fail (app.tests.SomeTestCase) ... ok\n"), 0644))
+	testRun, err = parseTestRun(settings)
+	require.NoError(t, err)
+	require.Len(t, testRun.TestResults, 5)
+	require.Equal(t, "app.tests.SomeTestCase.test_fail", testRun.TestResults[0].Id)
+	require.Equal(t, TestPassed, testRun.TestResults[0].Status)
+	require.Equal(t, TestPassed, testRun.Tests[1].Result.Status)
+	// a test the report has twice, like a rerun, keeps its first result too
+	require.NoError(t, os.WriteFile(settings.pytestReportPath, []byte("test_fail (app.tests.SomeTestCase) ... ok\ntest_fail (app.tests.SomeTestCase) ... FAIL\n"), 0644))
+	rerun, err := parseTestRun(settings)
+	require.NoError(t, err)
+	require.Equal(t, TestPassed, rerun.Tests[1].Result.Status)
+	require.Equal(t, []string{"tests.test_views.test_view"}, testRun.FailedTestIds)
+	require.Len(t, testRun.TestFailures, 1)
+	require.Equal(t, "ValueError: no view", testRun.TestFailures[0].Exception)
+	settings.pytestReportPath = ""
+
+	// reports that aren't well-formed are an error
+	require.NoError(t, os.WriteFile(settings.junitReportPaths[0], []byte(`<testsuite><testcase name="x">`), 0644))
+	_, err = parseTestRun(settings)
+	require.ErrorContains(t, err, "junit.xml")
+}
+
+func TestPytestReport(t *testing.T) {
+	require.Equal(t, "tests.test_views.ViewTestCase.test_list", TestIdFromPytestNodeId("tests/test_views.py::ViewTestCase::test_list"))
+	require.Equal(t, "tests.test_views.test_detail[1.5]", TestIdFromPytestNodeId("tests/test_views.py::test_detail[1.5]"))
+	require.Equal(t, "tests/test_views.py::ViewTestCase::test_list", PytestNodeIdFromTestId("tests.test_views.ViewTestCase.test_list"))
+	require.Equal(t, "tests/test_views.py::test_detail[1.5]", PytestNodeIdFromTestId("tests.test_views.test_detail[1.5]"))
+
+	line := func(query string) string {
+		return "2023-03-24T23:20:49Z DEBUG [conn 1] Query finished in 1 ms {connectTime=2023-03-24T23:20:49Z, connectionDb=nautobot, query=" + query + "}"
+	}
+	settings := writeTestLog(t, []string{
+		line("select 'dolt: setUp, test id = tests.test_views.ViewTestCase.test_list'"),
+		line("SELECT 1"),
+		line("select 'dolt: _post_teardown, test id = tests.test_views.ViewTestCase.test_list'"),
+	})
+	settings.pytestReportPath = filepath.Join(t.TempDir(), "pytest.txt")
+	require.NoError(t, os.WriteFile(settings.pytestReportPath, []byte(`============================= test session starts ==============================
+platform linux -- Python 3.11.2, pytest-7.2.2, pluggy-1.0.0
+collected 5 items
+
+tests/test_views.py::ViewTestCase::test_list FAILED                      [ 20%]
+tests/test_views.py::ViewTestCase::test_create PASSED                    [ 40%]
+tests/test_views.py::ViewTestCase::test_create ERROR                     [ 40%]
+tests/test_views.py::test_detail[1.5] SKIPPED (not on dolt)              [ 60%]
+tests/test_views.py::test_legacy XFAIL (known bug)                       [ 80%]
+tests/test_views.py::test_fixed XPASS                                    [100%]
+
+=================================== ERRORS =====================================
+______________ ERROR at teardown of ViewTestCase.test_create ___________________
+
+    def tearDown(self):
+>       self.client.logout()
+E       RuntimeError: no session
+
+tests/test_views.py:30: RuntimeError
+=================================== FAILURES ===================================
+_________________________ ViewTestCase.test_list _______________________________
+
+    def test_list(self):
+>       self.check(0, 2)
+
+tests/test_views.py:12: 
+_ _ _ _ _ _ _ _ _ _ _ _ _ _ _ _ _ _ _ _ _ _ _ _ _ _ _ _ _ _ _ _ _ _ _ _ _ _ _ _
+
+    def check(self, a, b):
+>       assert a == b
+E       assert 0 == 2
+
+tests/test_views.py:20: AssertionError
+=========================== short test summary info ============================
+FAILED tests/test_views.py::ViewTestCase::test_list - assert 0 == 2
+ERROR tests/test_views.py::ViewTestCase::test_create - RuntimeError: no session
+======== 1 failed, 1 passed, 1 skipped, 1 xfailed, 1 xpassed, 1 error in 0.12s =========
+`), 0644))
+
+	testRun, err := parseTestRun(settings)
+	require.NoError(t, err)
+	results := []string{}
+	for _, result := range testRun.TestResults {
+		results = append(results, fmt.Sprintf("%d %s %s %s", result.Order, result.Id, result.Status, result.Reason))
+	}
+	require.Equal(t, []string{
+		"1 tests.test_views.ViewTestCase.test_list FAIL ",
+		"2 tests.test_views.ViewTestCase.test_create ERROR ",
+		"3 tests.test_views.test_detail[1.5] skipped not on dolt",
+		"4 tests.test_views.test_legacy expected failure known bug",
+		"5 tests.test_views.test_fixed unexpected success ",
+	}, results)
+	require.Equal(t, []string{
+		"tests.test_views.ViewTestCase.test_list",
+		"tests.test_views.ViewTestCase.test_create",
+		"tests.test_views.test_fixed",
+	}, testRun.FailedTestIds)
+
+	require.Len(t, testRun.TestFailures, 3)
+	failure := testRun.TestFailures[0]
+	require.Equal(t, []TracebackFrame{
+		{File: "tests/test_views.py", Line: 12},
+		{File: "tests/test_views.py", Line: 20},
+	}, failure.Frames)
+	require.Equal(t, "assert 0 == 2 (test_views.py:20)", failure.Summary())
+	require.Equal(t, "RuntimeError: no session", testRun.TestFailures[1].Exception)
+	require.Equal(t, 13, testRun.TestFailures[1].LineNumber)
+	require.Empty(t, testRun.TestFailures[2].Exception)
+	require.Equal(t, "assert 0 == 2 (test_views.py:20)", testRun.Tests[0].Failure.Summary())
+}
+
+func TestSuspects(t *testing.T) {
+	line := func(query string) string {
+		return "2023-03-24T23:20:49Z DEBUG [conn 1] Query finished in 1 ms {connectTime=2023-03-24T23:20:49Z, connectionDb=nautobot, query=" + query + "}"
+	}
+	testLog := func(testId string, queries ...string) []string {
+		logs := []string{line(fmt.Sprintf("select 'dolt: setUp, test id = app.tests.SomeTestCase.%s'", testId))}
+		for _, query := range queries {
+			logs = append(logs, line(query))
+		}
+		return append(logs, line(fmt.Sprintf("select 'dolt: _post_teardown, test id = app.tests.SomeTestCase.%s'", testId)))
+	}
+	logs := testLog("test_one", "SET autocommit = 0", "SELECT * FROM a WHERE JSON_UNQUOTE(a.args) LIKE '%x%'")
+	logs = append(logs, testLog("test_two", "SET autocommit = 0", "SELECT * FROM a WHERE JSON_UNQUOTE(a.args) LIKE '%y%'", "SELECT * FROM b")...)
+	logs = append(logs, testLog("test_three", "SET autocommit = 0", "SELECT * FROM a WHERE a.id = 1")...)
+	logs = append(logs, testLog("test_four", "SET autocommit = 0", "SELECT * FROM b")...)
+	settings := writeTestLog(t, logs)
+	settings.pytestReportPath = filepath.Join(t.TempDir(), "report.txt")
+	require.NoError(t, os.WriteFile(settings.pytestReportPath, []byte(pytestReportSeparator+`
+FAIL: test_one (app.tests.SomeTestCase)
+`+pytestReportSeparator+`
+FAIL: test_two (app.tests.SomeTestCase)
+`), 0644))
+
+	testRun, err := parseTestRun(settings)
+	require.NoError(t, err)
+	suspects := rankSuspects(testRun.Tests)
+	top := []string{}
+	for _, suspect := range suspects[:4] {
+		top = append(top, fmt.Sprintf("%s %s %.3f %.3f %d %d", suspect.Kind, suspect.Key, suspect.Ochiai, suspect.Tarantula,
+			len(suspect.FailingTests), suspect.PassingTests))
+	}
+	likeShape := testRun.Tests[0].Queries[2].Fingerprint
+	require.Equal(t, []string{
+		fmt.Sprintf("shape %s 1.000 1.000 2 0", likeShape.Id),
+		"construct expression.Like 1.000 1.000 2 0",
+		"construct function JSON_UNQUOTE 1.000 1.000 2 0",
+		"construct plan.Filter 0.816 0.667 2 1",
+	}, top)
+	require.Equal(t, []string{"app.tests.SomeTestCase.test_one", "app.tests.SomeTestCase.test_two"}, suspects[0].FailingTests)
+	for _, suspect := range suspects {
+		// every test sets autocommit, so it doesn't tell failing tests apart
+		if suspect.Text == "set autocommit = ?" {
+			require.InDelta(t, 0.707, suspect.Ochiai, 0.001)
+			require.InDelta(t, 0.5, suspect.Tarantula, 0.001)
+		}
+	}
+
+	settings.suspectCount = 2
+	result, err := mainLogic(settings)
+	require.NoError(t, err)
+	outBytes, err := os.ReadFile(result.suspectsOutputPath)
+	require.NoError(t, err)
+	require.Equal(t, 2+1, strings.Count(string(outBytes), analysisReportSeparator))
+	require.Contains(t, string(outBytes), "Failing tests: 2, passing tests: 2\n")
+	require.Contains(t, string(outBytes), likeShape.Text+"\nOchiai: 1.000, Tarantula: 1.000\n")
+	outBytes, err = os.ReadFile(result.analysisOutputPath)
+	require.NoError(t, err)
+	require.Contains(t, string(outBytes), fmt.Sprintf("Top suspect: shape %s (Ochiai 1.000)\n", likeShape.Id))
+}
+
+func TestDiffRuns(t *testing.T) {
+	line := func(query string) string {
+		return "2023-03-24T23:20:49Z DEBUG [conn 1] Query finished in 1 ms {connectTime=2023-03-24T23:20:49Z, connectionDb=nautobot, query=" + query + "}"
+	}
+	errorLine := func(query string, err string) string {
+		return "2023-03-24T23:20:49Z WARN [conn 1] error running query {connectTime=2023-03-24T23:20:49Z, connectionDb=nautobot, error=" + err + ", query=" + query + "}"
+	}
+	start := func(testId string) string {
+		return line(fmt.Sprintf("select 'dolt: setUp, test id = app.tests.SomeTestCase.%s'", testId))
+	}
+	finish := func(testId string) string {
+		return line(fmt.Sprintf("select 'dolt: _post_teardown, test id = app.tests.SomeTestCase.%s'", testId))
+	}
+	base := writeTestLog(t, []string{
+		start("test_one"), line("SELECT * FROM a WHERE id = 1"), line("SELECT * FROM b"), line("SELECT * FROM c WHERE id = 1"), finish("test_one"),
+		start("test_two"), line("SELECT * FROM x"), finish("test_two"),
+	})
+	compared := writeTestLog(t, []string{
+		start("test_one"), line("SELECT * FROM a WHERE id = 2"), line("SELECT * FROM d"), errorLine("SELECT * FROM c WHERE id = 2", "table not found: c"), finish("test_one"),
+		start("test_two"), line("SELECT * FROM x"), finish("test_two"),
+		start("test_three"), line("SELECT 1"), finish("test_three"),
+	})
+	compared.pytestReportPath = filepath.Join(t.TempDir(), "report.txt")
+	require.NoError(t, os.WriteFile(compared.pytestReportPath, []byte(pytestReportSeparator+`
+FAIL: test_two (app.tests.SomeTestCase)
+`), 0644))
+
+	baseRun, err := parseTestRun(base)
+	require.NoError(t, err)
+	comparedRun, err := parseTestRun(compared)
+	require.NoError(t, err)
+	diff := diffTestRuns(baseRun, comparedRun)
+	require.Equal(t, 3, diff.TestCount)
+	require.Len(t, diff.Tests, 3)
+
+	testOne := diff.Tests[0]
+	require.Equal(t, "app.tests.SomeTestCase.test_one", testOne.Id)
+	require.False(t, testOne.StatusChanged())
+	require.Len(t, testOne.Removed, 1)
+	require.Equal(t, "SELECT * FROM b", testOne.Removed[0].Text)
+	require.Len(t, testOne.Added, 1)
+	require.Equal(t, "SELECT * FROM d", testOne.Added[0].Text)
+	require.Len(t, testOne.ErrorChanges, 1)
+	require.Equal(t, "table not found: c", testOne.ErrorChanges[0].Second.Error)
+
+	require.Equal(t, "app.tests.SomeTestCase.test_two", diff.Tests[1].Id)
+	require.Equal(t, testRanUnreported, diff.Tests[1].BaseStatus)
+	require.Equal(t, TestFailed, diff.Tests[1].ComparedStatus)
+	require.True(t, diff.Tests[1].StatusChanged())
+	require.False(t, diff.Tests[1].QueriesChanged())
+	require.Equal(t, testNotRun, diff.Tests[2].BaseStatus)
+	require.Len(t, diff.Tests[2].Added, 2)
+
+	require.Empty(t, diff.ErrorsOnlyInBase)
+	require.Len(t, diff.ErrorsOnlyInCompared, 1)
+	require.Equal(t, 1, diff.ErrorsOnlyInCompared[0].Second)
+
+	// a test that only the report of the base run has is listed once, even when the compared run marked it
+	reportedOnly := diffTestRuns(TestRun{TestResults: []TestResult{{Id: "app.tests.T.test", Status: TestPassed}}},
+		TestRun{Tests: []Test{{Id: "app.tests.T.test"}}})
+	require.Equal(t, 1, reportedOnly.TestCount)
+
+	// the queries are aligned by the longest common subsequence of their fingerprints
+	queries := func(texts ...string) []Query {
+		result := []Query{}
+		for _, text := range texts {
+			result = append(result, Query{Text: text, Fingerprint: NewFingerprint(text)})
+		}
+		return result
+	}
+	steps := []string{}
+	for _, step := range alignQueries(queries("SELECT 1", "SELECT a", "SELECT b", "SELECT c", "SELECT 2"), queries("SELECT 3", "SELECT b", "SELECT x", "SELECT c", "SELECT 4")) {
+		switch {
+		case step.First == nil:
+			steps = append(steps, "+ "+step.Second.Text)
+		case step.Second == nil:
+			steps = append(steps, "- "+step.First.Text)
+		default:
+			steps = append(steps, "  "+step.Second.Text)
+		}
+	}
+	require.Equal(t, []string{"  SELECT 3", "- SELECT a", "  SELECT b", "+ SELECT x", "  SELECT c", "  SELECT 4"}, steps)
+
+	result, err := DiffTestRuns(base, compared)
+	require.NoError(t, err)
+	outBytes, err := os.ReadFile(result.diffOutputPath)
+	require.NoError(t, err)
+	require.Contains(t, string(outBytes), "Tests only in compared: 1\nTests with a changed status: 2\nTests with changed queries: 2\n")
+	require.Contains(t, string(outBytes), "Status: ran -> FAIL\n")
+	require.Contains(t, string(outBytes), "- base line 3: SELECT * FROM b\n")
+	require.Contains(t, string(outBytes), "+ compared line 3: SELECT * FROM d\n")
+	require.Contains(t, string(outBytes), "  base error: none\n  compared error: table not found: c\n")
+}
+
+func TestSchemaHistory(t *testing.T) {
+	line := func(query string) string {
+		return "2023-03-24T23:20:49Z DEBUG [conn 1] Query finished in 1 ms {connectTime=2023-03-24T23:20:49Z, connectionDb=app, query=" + query + "}"
+	}
+	patch := func(table string, statements ...string) string {
+		items := []string{}
+		for i, statement := range statements {
+			items = append(items, fmt.Sprintf("(%d, '%s')", i+1, base64.StdEncoding.EncodeToString([]byte(statement))))
+		}
+		return fmt.Sprintf("Sending query: SELECT statement_order, TO_BASE64(statement) FROM DOLT_PATCH('HEAD', 'WORKING', '%s');\nResult: (%s)\n", table, strings.Join(items, ", "))
+	}
+	settings := writeTestLog(t, []string{
+		line("CREATE TABLE a (id int PRIMARY KEY, name varchar(10))"),
+		"2023-03-24T23:20:49Z WARN [conn 1] error running query {connectTime=2023-03-24T23:20:49Z, connectionDb=app, error=table with name a already exists, query=CREATE TABLE a (id int PRIMARY KEY)}",
+		line("ALTER TABLE a ADD COLUMN c int, ADD INDEX a_c (c)"),
+		line("SELECT * FROM a"),
+		line("RENAME TABLE a TO b"),
+		line("SELECT statement_order, TO_BASE64(statement) FROM DOLT_PATCH('HEAD', 'WORKING', 'd')"),
+		line("DROP TABLE b"),
+		line("ALTER TABLE missing ADD COLUMN x int"),
+		line("CREATE DATABASE app"),
+		"2023-03-24T23:20:49Z DEBUG [conn 2] Query finished in 1 ms {connectTime=2023-03-24T23:20:49Z, connectionDb=shop, query=CREATE DATABASE shop}",
+		line("ALTER TABLE c ADD CONSTRAINT c_missing FOREIGN KEY (id) REFERENCES missing (id)"),
+	})
+	settings.pytestReportPath = filepath.Join(t.TempDir(), "report.txt")
+	report := patch("c", "CREATE TABLE `c` (`id` int NOT NULL, PRIMARY KEY (`id`))", "INSERT INTO `c` VALUES (1)") +
+		patch("d", "CREATE TABLE `d` (`id` int NOT NULL, `a_id` int, PRIMARY KEY (`id`), CONSTRAINT `d_a` FOREIGN KEY (`a_id`) REFERENCES `a` (`id`))")
+	require.NoError(t, os.WriteFile(settings.pytestReportPath, []byte(report), 0644))
+
+	testRun, err := parseTestRun(settings)
+	require.NoError(t, err)
+	schema := testRun.Schema
+	require.Len(t, schema.Changes, 10)
+	// the patch of c isn't returned by a query of the log, it's the schema from before the log
+	require.Equal(t, 0, schema.Changes[0].LineNumber)
+	require.Equal(t, 2, schema.Changes[0].ReportLineNumber)
+	require.Equal(t, 6, schema.Changes[4].LineNumber)
+	require.Equal(t, "app", schema.Changes[4].Database)
+	failed := schema.FailedChanges()
+	require.Len(t, failed, 2)
+	require.Equal(t, 8, failed[0].LineNumber)
+	// only the foreign keys of created tables may reference tables that don't exist
+	require.Equal(t, 11, failed[1].LineNumber)
+
+	_, ok := schema.Table("app", "a", 1)
+	require.False(t, ok)
+	table, ok := schema.Table("app", "a", 2)
+	require.True(t, ok)
+	require.Len(t, table.Schema, 2)
+	require.Empty(t, table.Indexes)
+	table, ok = schema.Table("APP", "A", 4)
+	require.True(t, ok)
+	require.Len(t, table.Schema, 3)
+	require.Len(t, table.Indexes, 1)
+	require.Equal(t, "a_c", table.Indexes[0].ID())
+	_, ok = schema.Table("app", "a", 6)
+	require.False(t, ok)
+	table, ok = schema.Table("app", "b", 6)
+	require.True(t, ok)
+	require.Equal(t, "b", table.Name)
+	require.Len(t, schema.Tables(1), 1)
+	require.Len(t, schema.Tables(7), 3)
+
+	final := schema.Final()
+	require.Len(t, final, 2)
+	require.Equal(t, "c", final[0].Name)
+	require.Equal(t, "d", final[1].Name)
+	dump := schema.Dump()
+	require.True(t, strings.HasPrefix(dump, "SET FOREIGN_KEY_CHECKS = 0;\n\nCREATE DATABASE IF NOT EXISTS `app`;\nUSE `app`;\n\nCREATE TABLE `c`"))
+	require.Contains(t, dump, "CONSTRAINT `d_a` FOREIGN KEY (`a_id`) REFERENCES `a` (`id`)")
+}
+
+func TestQueryPlans(t *testing.T) {
+	line := func(duration int, query string) string {
+		return fmt.Sprintf("2023-03-24T23:20:49Z DEBUG [conn 1] Query finished in %d ms {connectTime=2023-03-24T23:20:49Z, connectionDb=app, query=%s}", duration, query)
+	}
+	settings := writeTestLog(t, []string{
+		line(1, "SELECT * FROM a WHERE id = 1"),
+		line(1, "CREATE TABLE a (id int PRIMARY KEY, b_id int, name varchar(10), KEY a_b (b_id))"),
+		line(1, "CREATE TABLE b (id int PRIMARY KEY, name varchar(10))"),
+		line(2, "SELECT * FROM a WHERE id = 1"),
+		line(3, "SELECT * FROM a WHERE name LIKE '%x%'"),
+		line(4, "SELECT a.name, b.name FROM a JOIN b ON a.b_id = b.id WHERE b.name = 'x'"),
+		line(5, "SELECT * FROM a, b WHERE a.name < b.name"),
+		line(6, "SELECT * FROM b WHERE id = 2"),
+	})
+	settings.resolvePlans = true
+
+	testRun, err := parseTestRun(settings)
+	require.NoError(t, err)
+	plans := testRun.QueryPlans
+	require.Len(t, plans, 5)
+	// the longest shapes first
+	require.Equal(t, 8, plans[0].LineNumber)
+	require.Empty(t, plans[0].TableScans)
+	require.Contains(t, plans[0].Explain, "IndexedTableAccess(b)")
+	require.Equal(t, 7, plans[1].LineNumber)
+	require.Equal(t, []string{"a", "b"}, plans[1].TableScans)
+	require.Equal(t, 1, plans[1].NestedLoopJoins)
+	require.Equal(t, 6, plans[2].LineNumber)
+	require.Equal(t, []string{"b"}, plans[2].TableScans)
+	require.Equal(t, []string{"LookupJoin"}, plans[2].Joins)
+	require.Zero(t, plans[2].NestedLoopJoins)
+	// the first query of the shape ran before the table was created
+	require.Equal(t, 1, plans[3].LineNumber)
+	require.Equal(t, 2, plans[3].Queries)
+	require.NotEmpty(t, plans[3].Error)
+	require.Nil(t, plans[3].Node)
+	require.Equal(t, 5, plans[4].LineNumber)
+	require.Equal(t, []string{"a"}, plans[4].TableScans)
+
+	// the plans are written as they are, the % of LIKE patterns isn't a format verb
+	result, err := mainLogic(settings)
+	require.NoError(t, err)
+	outBytes, err := os.ReadFile(result.plansOutputPath)
+	require.NoError(t, err)
+	require.Contains(t, string(outBytes), "SELECT * FROM a WHERE name LIKE '%x%'\n")
+}
+
+// upperLogFormat is a log format registered by TestLogFormats
+type upperLogFormat struct {
+	plainLogFormat
+}
+
+func (upperLogFormat) Name() string {
+	return "upper"
+}
+
+func (upperLogFormat) Detect(banner ServerBanner) bool {
+	return banner["L"] == "trace"
+}
+
+func (upperLogFormat) DecodeQuery(query string) (string, error) {
+	return strings.ToUpper(query), nil
+}
+
+func TestLogFormats(t *testing.T) {
+	line := func(message string, query string) string {
+		return "2023-03-24T23:20:49Z DEBUG [conn 1] " + message + " {connectTime=2023-03-24T23:20:49Z, connectionDb=nautobot, query=" + query + "}"
+	}
+	banner := `Starting server with Config HP="0.0.0.0:3306"|T="28800000"|R="false"|L="debug"`
+	oldBanner := `Starting server with Config HP="localhost:3306"|U="root"|P=""|T="28800000"|R="false"|L="debug"`
+
+	// detection
+	parsedBanner, ok := ParseServerBanner(oldBanner)
+	require.True(t, ok)
+	require.Equal(t, ServerBanner{"HP": "localhost:3306", "U": "root", "P": "", "T": "28800000", "R": "false", "L": "debug"}, parsedBanner)
+	require.Equal(t, "multiline", DetectLogFormat(parsedBanner).Name())
+	parsedBanner, ok = ParseServerBanner(banner)
+	require.True(t, ok)
+	require.Equal(t, "base64", DetectLogFormat(parsedBanner).Name())
+	_, ok = ParseServerBanner(line("Query finished in 1 ms", "U0VMRUNUIDE="))
+	require.False(t, ok)
+	_, err := LogFormatByName("gzip")
+	require.Error(t, err)
+
+	// a base64 log with a query that isn't encoded reports it instead of guessing
+	settings := writeDetectedTestLog(t, []string{
+		banner,
+		line("Query finished in 1 ms", "U0VMRUNUIDE="),
+		line("Query finished in 1 ms", "SELECT 2"),
+	})
+	testRun, err := parseTestRun(settings)
+	require.NoError(t, err)
+	require.Equal(t, []string{"base64"}, testRun.LogFormats)
+	require.Len(t, testRun.Queries.All, 2)
+	require.Equal(t, "SELECT 1", testRun.Queries.All[0].Text)
+	require.Equal(t, "SELECT 2", testRun.Queries.All[1].Text)
+	require.Contains(t, testRun.Queries.All[1].ParseError, "query is not base64 encoded")
+
+	// the format given on the command line wins over the banner
+	settings.logFormat = "plain"
+	testRun, err = parseTestRun(settings)
+	require.NoError(t, err)
+	require.Equal(t, "U0VMRUNUIDE=", testRun.Queries.All[0].Text)
+	require.Empty(t, testRun.Queries.All[1].ParseError)
+
+	// queries of older releases continue on the following lines, until a banner switches to the newer format
+	settings = writeDetectedTestLog(t, []string{
+		oldBanner,
+		strings.TrimSuffix(line("Starting query", "SELECT a,"), "}"),
+		"  b, c, d, e, f",
+		"FROM t}",
+		strings.TrimSuffix(line("Query finished in 1 ms", "SELECT a,"), "}"),
+		"  b, c, d, e, f",
+		"FROM t}",
+		line("Query finished in 1 ms", "SELECT 2"),
+		banner,
+		line("Query finished in 1 ms", "U0VMRUNUIDM="),
+	})
+	testRun, err = parseTestRun(settings)
+	require.NoError(t, err)
+	require.Equal(t, []string{"multiline", "base64"}, testRun.LogFormats)
+	queries := testRun.Queries.All
+	require.Len(t, queries, 3)
+	require.Equal(t, "SELECT a,\n  b, c, d, e, f\nFROM t", queries[0].Text)
+	require.Equal(t, 5, queries[0].LineNumber)
+	require.Equal(t, 2, queries[0].StartLineNumber)
+	require.Empty(t, queries[0].ParseError)
+	require.Equal(t, "SELECT 2", queries[1].Text)
+	require.Equal(t, "SELECT 3", queries[2].Text)
+
+	// a multi-line record longer than the maximum line length is skipped as a whole
+	settings.maxLineLength = len(line("Query finished in 1 ms", "U0VMRUNUIDM="))
+	testRun, err = parseTestRun(settings)
+	require.NoError(t, err)
+	require.Equal(t, []int{2, 5}, testRun.SkippedLines)
+	require.Len(t, testRun.Queries.All, 2)
+
+	// formats can be added to the registry and are detected before the built-in ones
+	registered := logFormats
+	defer func() {
+		logFormats = registered
+	}()
+	RegisterLogFormat(upperLogFormat{})
+	require.Panics(t, func() {
+		RegisterLogFormat(upperLogFormat{})
+	})
+	settings = writeDetectedTestLog(t, []string{
+		strings.Replace(banner, `L="debug"`, `L="trace"`, 1),
+		line("Query finished in 1 ms", "select 1"),
+	})
+	testRun, err = parseTestRun(settings)
+	require.NoError(t, err)
+	require.Equal(t, []string{"upper"}, testRun.LogFormats)
+	require.Equal(t, "SELECT 1", testRun.Queries.All[0].Text)
+}
+
+func TestServerBannerDetection(t *testing.T) {
+	line := func(query string) string {
+		return "2023-03-24T23:20:49Z DEBUG [conn 1] Query finished in 1 ms {connectTime=2023-03-24T23:20:49Z, connectionDb=nautobot, query=" + query + "}"
+	}
+
+	// without a banner, like a rotated log, queries are read as base64 and the analysis says so
+	settings := writeDetectedTestLog(t, []string{
+		line("U0VMRUNUIDE="),
+		line("ROLLBACK"),
+		line("SELECT 2"),
+	})
+	testRun, err := parseTestRun(settings)
+	require.NoError(t, err)
+	require.Equal(t, []string{"base64"}, testRun.LogFormats)
+	require.True(t, testRun.LogFormatAssumed)
+	queries := testRun.Queries.All
+	require.Len(t, queries, 3)
+	require.Equal(t, "SELECT 1", queries[0].Text)
+	require.Empty(t, queries[0].ParseError)
+	require.NotEqual(t, "ROLLBACK", queries[1].Text)
+	require.Contains(t, queries[2].ParseError, "not base64 encoded")
+	result, err := mainLogic(settings)
+	require.NoError(t, err)
+	analysis, err := os.ReadFile(result.analysisOutputPath)
+	require.NoError(t, err)
+	require.Contains(t, string(analysis), "Warning: the log has no server banner to detect its format from, its queries were read as base64")
+
+	// mixed decodes each query that is base64 of text, only when it's asked for
+	settings.logFormat = "mixed"
+	testRun, err = parseTestRun(settings)
+	require.NoError(t, err)
+	require.Equal(t, []string{"mixed"}, testRun.LogFormats)
+	require.False(t, testRun.LogFormatAssumed)
+	queries = testRun.Queries.All
+	require.Equal(t, "SELECT 1", queries[0].Text)
+	require.Equal(t, "ROLLBACK", queries[1].Text)
+	require.Equal(t, "SELECT 2", queries[2].Text)
+
+	// a banner after the start of the log, from a restarted server, switches to the format it's detected as
+	settings = writeDetectedTestLog(t, []string{
+		line("SELECT 1"),
+		`Starting server with Config HP="0.0.0.0:3306"|T="28800000"|R="false"|L="debug"`,
+		line("U0VMRUNUIDI="),
+	})
+	testRun, err = parseTestRun(settings)
+	require.NoError(t, err)
+	require.Equal(t, []string{"base64"}, testRun.LogFormats)
+	require.True(t, testRun.LogFormatAssumed)
+	require.NotEmpty(t, testRun.Queries.All[0].ParseError)
+	require.Equal(t, "SELECT 2", testRun.Queries.All[1].Text)
+
+	// logs with a banner don't warn
+	settings = writeDetectedTestLog(t, []string{
+		`Starting server with Config HP="0.0.0.0:3306"|T="28800000"|R="false"|L="debug"`,
+		line("U0VMRUNUIDI="),
+	})
+	testRun, err = parseTestRun(settings)
+	require.NoError(t, err)
+	require.False(t, testRun.LogFormatAssumed)
+}
+
+func TestMySQLLogs(t *testing.T) {
+	banner := "/usr/sbin/mysqld, Version: 8.0.32 (MySQL Community Server - GPL). started with:"
+	header := []string{
+		banner,
+		"Tcp port: 3306  Unix socket: /var/run/mysqld/mysqld.sock",
+		"Time                 Id Command    Argument",
+	}
+	parsedBanner, ok := ParseServerBanner(banner)
+	require.True(t, ok)
+	require.Equal(t, "mysql", DetectLogFormat(parsedBanner).Name())
+
+	// the general log has a line per command, queries continue on the following lines
+	settings := writeDetectedTestLog(t, append(header,
+		"2023-03-22T21:54:43.100000Z\t   12 Connect\troot@localhost on nautobot using TCP/IP",
+		"2023-03-22T21:54:43.200000Z\t   12 Query\tselect 'dolt: setUp, test id = app.tests.A.test_a'",
+		"2023-03-22T21:54:43.300000Z\t   12 Query\tSELECT a,",
+		"  b",
+		"FROM t",
+		"2023-03-22T21:54:43.400000Z\t   12 Prepare\tSELECT ?",
+		"2023-03-22T21:54:43.500000Z\t   12 Execute\tSELECT 1",
+		"2023-03-22T21:54:43.600000Z\t   12 Query\tselect 'dolt: _post_teardown, test id = app.tests.A.test_a'",
+		"2023-03-22T21:54:43.700000Z\t   12 Quit\t",
+	))
+	testRun, err := parseTestRun(settings)
+	require.NoError(t, err)
+	require.Equal(t, []string{"mysql"}, testRun.LogFormats)
+	require.Empty(t, testRun.PairingIssues)
+	queries := testRun.Queries.All
+	require.Len(t, queries, 4)
+	require.Equal(t, "SELECT a,\n  b\nFROM t", queries[1].Text)
+	require.Equal(t, 6, queries[1].LineNumber)
+	require.Equal(t, 12, queries[1].ConnectionId)
+	require.Equal(t, "app.tests.A.test_a", queries[1].TestId)
+	require.Equal(t, "SELECT 1", queries[2].Text)
+	require.Len(t, testRun.Tests, 1)
+	require.Len(t, testRun.Tests[0].Queries, 3)
+	require.Len(t, testRun.Connections, 1)
+	require.Equal(t, 4, testRun.Connections[0].OpenLineNumber)
+	require.Equal(t, 12, testRun.Connections[0].CloseLineNumber)
+
+	// the slow log has a block per query with its duration, the time is left out when it didn't change
+	settings = writeDetectedTestLog(t, append(header,
+		"# Time: 2023-03-22T21:54:43.123456Z",
+		"# User@Host: root[root] @ localhost [127.0.0.1]  Id:    12",
+		"# Query_time: 0.250000  Lock_time: 0.000002 Rows_sent: 1  Rows_examined: 0",
+		"use nautobot;",
+		"SET timestamp=1679522083;",
+		"select 'dolt: setUp, test id = app.tests.A.test_a';",
+		"# User@Host: root[root] @ localhost [127.0.0.1]  Id:    13",
+		"# Query_time: 1.500000  Lock_time: 0.000002 Rows_sent: 1  Rows_examined: 0 Thread_id: 13 Errno: 1146 Killed: 0",
+		"SET timestamp=1679522084;",
+		"SELECT a,",
+		"  b FROM missing;",
+		"# Time: 230322 21:54:45",
+		"# User@Host: root[root] @ localhost [127.0.0.1]  Id:    12",
+		"# Query_time: 0.001000  Lock_time: 0.000002 Rows_sent: 1  Rows_examined: 0",
+		"SET timestamp=1679522085;",
+		"# administrator command: Quit;",
+	))
+	testRun, err = parseTestRun(settings)
+	require.NoError(t, err)
+	require.Empty(t, testRun.PairingIssues)
+	queries = testRun.Queries.All
+	require.Len(t, queries, 2)
+	require.Equal(t, 4, queries[0].LineNumber)
+	require.Equal(t, 250*time.Millisecond, queries[0].Duration)
+	require.Equal(t, "nautobot", queries[0].ConnectionDb)
+	require.Equal(t, "app.tests.A.test_a", queries[0].TestId)
+	require.Equal(t, "SELECT a,\n  b FROM missing", queries[1].Text)
+	require.Equal(t, 13, queries[1].ConnectionId)
+	require.Equal(t, 1500*time.Millisecond, queries[1].Duration)
+	require.Equal(t, "Errno: 1146", queries[1].Error)
+	require.Equal(t, time.Unix(1679522084, 0).UTC(), queries[1].Timestamp)
+}
+
+func TestLogInputs(t *testing.T) {
+	// prepare
+	dir := t.TempDir()
+	line := func(query string) string {
+		return "2023-03-24T23:20:49Z DEBUG [conn 1] Query finished in 1 ms {connectTime=2023-03-24T23:20:49Z, connectionDb=nautobot, query=" + query + "}"
+	}
+	// the first file doesn't end with a line ending, the next line mustn't be appended to its last one
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "dolt-sql.log.1"), []byte(line("SELECT 1")), 0644))
+
+	var gzipped bytes.Buffer
+	gzipWriter := gzip.NewWriter(&gzipped)
+	_, err := gzipWriter.Write([]byte(line("SELECT 2") + "\n"))
+	require.NoError(t, err)
+	require.NoError(t, gzipWriter.Close())
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "dolt-sql.log.2.gz"), gzipped.Bytes(), 0644))
+
+	var zstdCompressed bytes.Buffer
+	zstdWriter, err := zstd.NewWriter(&zstdCompressed)
+	require.NoError(t, err)
+	_, err = zstdWriter.Write([]byte(line("SELECT 3") + "\n" + line("SELECT 4") + "\n"))
+	require.NoError(t, err)
+	require.NoError(t, zstdWriter.Close())
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "dolt-sql.log.3.zst"), zstdCompressed.Bytes(), 0644))
+
+	paths, err := expandLogPaths([]string{filepath.Join(dir, "dolt-sql.log.*")})
+	require.NoError(t, err)
+	require.Len(t, paths, 3)
+	_, err = expandLogPaths([]string{filepath.Join(dir, "*.missing")})
+	require.Error(t, err)
+
+	// Run the main logic
+	settings := NewSettingsForLogs(paths, "", "")
+	settings.logger = NewTestLogger(t)
+	result, err := mainLogic(settings)
+	require.NoError(t, err)
+	require.Equal(t, filepath.Join(dir, "dolt-log.queries.txt"), result.queriesOutputPath)
+
+	testRun, err := parseTestRun(settings)
+	require.NoError(t, err)
+	queries := testRun.Queries.All
+	require.Len(t, queries, 4)
+	for i, query := range queries {
+		require.Equal(t, fmt.Sprintf("SELECT %d", i+1), query.Text)
+		require.Equal(t, i+1, query.LineNumber)
+	}
+	// with several logs, lines are reported in the log they're in
+	require.Equal(t, paths[0]+":1", testRun.LogLines.Location(1))
+	require.Equal(t, paths[1]+":1", testRun.LogLines.Location(2))
+	require.Equal(t, paths[2]+":2", testRun.LogLines.Location(4))
+	require.Contains(t, queries[3].String(false, testRun.LogLines), "Line "+paths[2]+":2\n")
+	outBytes, err := os.ReadFile(result.queriesOutputPath)
+	require.NoError(t, err)
+	require.Contains(t, string(outBytes), "Line "+paths[2]+":2\n")
+
+	// output is named after a single compressed log without its compression extension
+	settings = NewSettingsForLogs(paths[1:2], "", "")
+	require.Equal(t, filepath.Join(dir, "dolt-sql.log.queries.2"), settings.GetOutputFilePath(".queries"))
+	settings = NewSettingsForLogs(paths, "", filepath.Join(dir, "out", "run.txt"))
+	require.Equal(t, filepath.Join(dir, "out", "run.queries.txt"), settings.GetOutputFilePath(".queries"))
+
+	// stdin
+	stdin, err := os.Open(paths[2])
+	require.NoError(t, err)
+	defer stdin.Close()
+	realStdin := os.Stdin
+	os.Stdin = stdin
+	defer func() { os.Stdin = realStdin }()
+	settings = NewSettingsForLogs([]string{"-"}, "", filepath.Join(dir, "stdin.txt"))
+	settings.logger = NewTestLogger(t)
+	testRun, err = parseTestRun(settings)
+	require.NoError(t, err)
+	require.Len(t, testRun.Queries.All, 2)
+	require.Equal(t, "SELECT 4", testRun.Queries.All[1].Text)
+	require.Equal(t, "2", testRun.LogLines.Location(2))
+}
+
+func TestFlagValues(t *testing.T) {
+	settings := writeTestLog(t, []string{})
+	logPath := settings.doltLogPaths[0]
+	realArgs, realFlags := os.Args, flag.CommandLine
+	defer func() {
+		os.Args, flag.CommandLine = realArgs, realFlags
+	}()
+	for _, args := range [][]string{{"-slowest", "-1"}, {"-suspects", "-1"}, {"-max-line-length", "0"}} {
+		flag.CommandLine = flag.NewFlagSet("dolt-log-analyzer", flag.ContinueOnError)
+		os.Args = append([]string{"dolt-log-analyzer", "-log", logPath}, args...)
+		_, err := readInputs()
+		require.ErrorContains(t, err, args[0]+" must", args)
+	}
+	flag.CommandLine = flag.NewFlagSet("dolt-log-analyzer", flag.ContinueOnError)
+	os.Args = []string{"dolt-log-analyzer", "-log", logPath, "-slowest", "0", "-suspects", "0"}
+	_, err := readInputs()
+	require.NoError(t, err)
+
+	_, _, err = readDiffInputs([]string{"-base-log", logPath, "-log", logPath, "-max-line-length", "-1"})
+	require.ErrorContains(t, err, "-max-line-length must")
+}
+
+func TestFingerprint(t *testing.T) {
+	same := func(left, right string) {
+		leftFingerprint, rightFingerprint := NewFingerprint(left), NewFingerprint(right)
+		require.Equal(t, leftFingerprint, rightFingerprint, "%s\n%s", left, right)
+	}
+	different := func(left, right string) {
+		require.NotEqual(t, NewFingerprint(left).Id, NewFingerprint(right).Id, "%s\n%s", left, right)
+	}
+
+	same("SELECT * FROM `t` WHERE `id` = 'abc'", "select *  from t where id = 'def'")
+	same("SELECT * FROM t WHERE id IN ('a', 'b', 'c') LIMIT 21", "SELECT * FROM t WHERE id IN ('d') LIMIT 1")
+	same("INSERT INTO t (a, b) VALUES (1, 'x'), (2, 'y')", "INSERT INTO t (a, b) VALUES (3, 'z')")
+	same("SAVEPOINT `s281473537629440_x46`", "SAVEPOINT `s281473537629440_x47`")
+	same("ROLLBACK TO SAVEPOINT s1_x1", "ROLLBACK TO SAVEPOINT s2_x9")
+	same("SELECT U0.`id` FROM `dcim_platform` U0 WHERE U0.`name` = 'a'", "SELECT V1.`id` FROM `dcim_platform` V1 WHERE V1.`name` = 'b'")
+	same("SELECT COUNT(*) AS `__count` FROM t ORDER BY `__count`", "SELECT COUNT(*) AS `total` FROM t ORDER BY `total`")
+	same("SELECT /* test: a */ 1", "SELECT /* test: b */ 2")
+	same("select 'dolt: setUp, test id = a.b'", "select 'dolt: setUp, test id = c.d'")
+
+	different("SELECT * FROM t WHERE id = 1", "SELECT * FROM u WHERE id = 1")
+	different("SELECT * FROM t WHERE id = 1", "SELECT * FROM t WHERE id IS NULL")
+
+	fingerprint := NewFingerprint("SELECT * FROM `t` WHERE `id` IN (1, 2) AND `name` = 'x' LIMIT 21")
+	require.Equal(t, "select * from t where id in (?+) and name = ? limit ?", fingerprint.Text)
+	require.Regexp(t, "^0x[0-9A-F]{16}$", fingerprint.Id)
+	// ids only depend on the text, so they can be referenced across runs
+	require.Equal(t, "0x16219655761820A2", NewFingerprint("SELECT 1").Id)
+
+	// queries vitess can't parse are normalized textually
+	fingerprint = NewFingerprint("FROBNICATE t WHERE id IN (1, 2, 3) AND name = 'x' -- comment")
+	require.Equal(t, "frobnicate t where id in (?+) and name = ?", fingerprint.Text)
+
+	// the test queries of the analysis are grouped by their fingerprint, not by their literals
+	collection := NewQueryCollection()
+	for _, query := range []Query{
+		{Text: "SELECT * FROM t WHERE id = 'abc'", TestId: "a.b"},
+		{Text: "SELECT * FROM t WHERE id = 'def'", TestId: "a.c"},
+		{Text: "SELECT * FROM t WHERE id = 'ghi'"},
+	} {
+		query.Fingerprint = NewFingerprint(query.Text)
+		collection.Add(query)
+	}
+	groups := sortQueries(collection)
+	require.Len(t, groups, 1)
+	require.Equal(t, "select * from t where id = ?", groups[0].First.Text)
+	require.Len(t, groups[0].Second, 2)
+}
+
+func TestDropExtraneousData(t *testing.T) {
+	normalize := func(query string) string {
+		node, err := parse.Parse(sql.NewEmptyContext(), query)
+		require.NoError(t, err, query)
+		node, err = DropExtraneousData(node)
+		require.NoError(t, err, query)
+		if execute, ok := node.(*plan.ExecuteQuery); ok {
+			// EXECUTE panics when it's printed
+			return execute.Name
+		}
+		return sql.DebugString(node)
+	}
+	same := func(left, right string) {
+		require.Equal(t, normalize(left), normalize(right), "%s\n%s", left, right)
+	}
+
+	same("SELECT * FROM t WHERE a = 'x' AND b = 1.5 AND c IS NULL", "SELECT * FROM t WHERE a = 'y' AND b = 2.5 AND c IS NULL")
+	same("SELECT * FROM t WHERE (a, b) IN ((1, 'x'), (2, 'y'))", "SELECT * FROM t WHERE (a, b) IN ((3, 'z'), (4, 'w'))")
+	same("SELECT * FROM t WHERE a = x'abcd' OR a = b'101'", "SELECT * FROM t WHERE a = x'ef' OR a = b'1'")
+	same("SELECT * FROM t WHERE a = ? AND b = ?", "SELECT * FROM t WHERE a = ? AND b = ?")
+	same("INSERT INTO t VALUES (1, 'a', NULL), (2, 'b', NULL)", "INSERT INTO t VALUES (3, 'c', NULL), (4, 'd', NULL)")
+	same("SELECT U0.id AS x FROM t U0 WHERE U0.id = 1 ORDER BY x", "SELECT V1.id AS y FROM t V1 WHERE V1.id = 2 ORDER BY y")
+	same("SELECT * FROM t WHERE id IN (SELECT U0.id FROM u U0 WHERE U0.name = 'a')", "SELECT * FROM t WHERE id IN (SELECT V0.id FROM u V0 WHERE V0.name = 'b')")
+	same("WITH a AS (SELECT 1) SELECT * FROM a", "WITH b AS (SELECT 2) SELECT * FROM b")
+	same("SAVEPOINT s1_x1", "SAVEPOINT s2_x2")
+	same("RELEASE SAVEPOINT s1_x1", "RELEASE SAVEPOINT s2_x2")
+	same("ROLLBACK TO SAVEPOINT s1_x1", "ROLLBACK TO SAVEPOINT s2_x2")
+	same("PREPARE a FROM 'SELECT * FROM t WHERE id = 1'", "PREPARE b FROM 'SELECT * FROM t WHERE id = 2'")
+	same("EXECUTE a USING @x", "EXECUTE b USING @y")
+	same("DEALLOCATE PREPARE a", "DEALLOCATE PREPARE b")
+
+	// every literal type the parser and the analyzer can produce has a placeholder
+	for _, dataType := range []sql.Type{
+		types.Null, types.Text, types.LongBlob, types.Int8, types.Uint64, types.Float64, types.MustCreateDecimalType(10, 2),
+		types.JSON, types.Date, types.Datetime, types.Timestamp, types.Time, types.Year, types.MustCreateBitType(3),
+		types.MustCreateEnumType([]string{"a", "b"}, sql.Collation_Default), types.MustCreateSetType([]string{"a", "b"}, sql.Collation_Default),
+		types.PointType{}, types.TupleType{types.Int8, types.Text},
+	} {
+		placeholder, err := getPlaceholder(dataType)
+		require.NoError(t, err, dataType.String())
+		require.NotNil(t, placeholder, dataType.String())
+	}
+	literal, _, err := newTreeNormalizer().expression(expression.NewBindVar("v1"))
+	require.NoError(t, err)
+	require.Equal(t, "BindVar(v1)", literal.String())
+
+	// anything else is an error rather than a panic
+	_, err = getPlaceholder(unknownType{types.Int8})
+	require.Error(t, err)
+	_, err = DropExtraneousData(nil)
+	require.Error(t, err)
+
+	// the queries of a log keep their normalized tree
+	line := func(query string) string {
+		return "2023-03-24T23:20:49Z DEBUG [conn 1] Query finished in 1 ms {connectTime=2023-03-24T23:20:49Z, connectionDb=app, query=" + query + "}"
+	}
+	settings := writeTestLog(t, []string{
+		line("SELECT * FROM t WHERE a = 1"),
+		line("SELECT * FROM t WHERE a = 2"),
+		line("EXECUTE a USING @x"),
+	})
+	testRun, err := parseTestRun(settings)
+	require.NoError(t, err)
+	queries := testRun.Queries.All
+	require.Len(t, queries, 3)
+	require.NotEmpty(t, queries[0].NormalizedTree)
+	require.Equal(t, queries[0].NormalizedTree, queries[1].NormalizedTree)
+	require.NotEqual(t, queries[0].NodeDebug, queries[1].NodeDebug)
+	require.Contains(t, queries[0].String(false, nil), "Normalized tree:\n"+queries[0].NormalizedTree)
+	require.Contains(t, queries[2].NormalizedTree, "Execute(placeholder)")
+}
+
+// unknownType is a type the normalization doesn't know about
+type unknownType struct {
+	baseType
+}
+
+// baseType can be embedded, sql.Type can't since it has a Type method
+type baseType = sql.Type
+
+func TestParseWorkers(t *testing.T) {
+	logPath, _ := writeSyntheticLog(t, 50)
+	parse := func(workers int) TestRun {
+		settings := NewSettings(logPath, "")
+		settings.logger = NewTestLogger(t)
+		settings.parseWorkers = workers
+		testRun, err := parseTestRun(settings)
+		require.NoError(t, err)
+		return testRun
+	}
+
+	serial := parse(1)
+	require.Len(t, serial.Queries.All, 50*7)
+	require.Len(t, serial.Tests, 50)
+	parallel := parse(8)
+	require.Equal(t, len(serial.Queries.All), len(parallel.Queries.All))
+	for i, query := range serial.Queries.All {
+		other := parallel.Queries.All[i]
+		require.Equal(t, query.LineNumber, other.LineNumber)
+		require.Equal(t, query.StartLineNumber, other.StartLineNumber)
+		require.Equal(t, query.TestId, other.TestId)
+		require.Equal(t, query.NodeDebug, other.NodeDebug)
+		require.Equal(t, query.TransactionId, other.TransactionId)
+	}
+	require.Equal(t, serial.Transactions, parallel.Transactions)
+}
+
+func BenchmarkParseQueries(b *testing.B) {
+	logPath, size := writeSyntheticLog(b, 2_000)
+	for _, workers := range []int{1, 2, 4, 8} {
+		b.Run(fmt.Sprintf("workers=%d", workers), func(b *testing.B) {
+			settings := NewSettings(logPath, "")
+			settings.logger = NewNoopLogger()
+			settings.parseWorkers = workers
+			b.SetBytes(size)
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				_, err := parseTestRun(settings)
+				require.NoError(b, err)
+			}
+		})
+	}
+}
+
+func BenchmarkRegexSplit(b *testing.B) {
+	line := "2023-03-24T23:20:49Z DEBUG [conn 1] Query finished in 1 ms {connectTime=2023-03-24T23:20:49Z, connectionDb=nautobot, query=SELECT 1}"
+	for i := 0; i < b.N; i++ {
+		RegexSplit(line, logLineRegex)
+	}
+}
+
+// writeSyntheticLog writes a log shaped like a Django test suite's, with the given number of tests, and returns
+// its path and size.
+func writeSyntheticLog(b testing.TB, testCount int) (string, int64) {
+	logPath := b.TempDir() + "/dolt-sql.log"
+	sb := strings.Builder{}
+	sb.WriteString(`Starting server with Config HP="0.0.0.0:3306"|T="28800000"|R="false"|L="debug"` + "\n")
+	line := func(connection int, query string) {
+		encoded := base64.StdEncoding.EncodeToString([]byte(query))
+		sb.WriteString(fmt.Sprintf("2023-03-24T23:20:49Z DEBUG [conn %d] Starting query {connectTime=2023-03-24T23:20:49Z, connectionDb=nautobot, query=%s}\n", connection, encoded))
+		sb.WriteString(fmt.Sprintf("2023-03-24T23:20:49Z DEBUG [conn %d] Query finished in 1 ms {connectTime=2023-03-24T23:20:49Z, connectionDb=nautobot, query=%s}\n", connection, encoded))
+	}
+	for i := 0; i < testCount; i++ {
+		connection := i%8 + 1
+		testId := fmt.Sprintf("app.tests.test_filters.Case%d.test_%d", i/10, i)
+		line(connection, fmt.Sprintf("select 'dolt: setUp, test id = %s'", testId))
+		line(connection, fmt.Sprintf("SAVEPOINT `s281473537629440_x%d`", i))
+		line(connection, fmt.Sprintf("INSERT INTO `dcim_platform` (`id`, `name`, `slug`) VALUES ('%d', 'Platform %d', 'platform-%d')", i, i, i))
+		line(connection, fmt.Sprintf("SELECT U0.`id`, U0.`name` FROM `dcim_platform` U0 INNER JOIN `dcim_device` U1 ON (U0.`id` = U1.`platform_id`) WHERE U0.`slug` IN ('a-%d', 'b-%d') ORDER BY U0.`name` ASC LIMIT 21", i, i))
+		line(connection, fmt.Sprintf("UPDATE `dcim_platform` SET `name` = 'Renamed %d' WHERE `dcim_platform`.`id` = '%d'", i, i))
+		line(connection, fmt.Sprintf("ROLLBACK TO SAVEPOINT `s281473537629440_x%d`", i))
+		line(connection, fmt.Sprintf("select 'dolt: _post_teardown, test id = %s'", testId))
+	}
+	require.NoError(b, os.WriteFile(logPath, []byte(sb.String()), 0644))
+	return logPath, int64(sb.Len())
+}
+
+// writeTestLog writes the log lines to a temporary dolt log and returns settings for analyzing it.
+func writeTestLog(t *testing.T, logs []string) Settings {
+	dir := t.TempDir()
+	input, err := os.CreateTemp(dir, "dolt-sql.log")
+	require.NoError(t, err)
+
+	for _, log := range logs {
+		_, err = input.WriteString(log + "\n")
+		require.NoError(t, err)
+	}
+	err = input.Close()
+	require.NoError(t, err)
+
+	settings := NewSettings(input.Name(), "")
+	settings.logger = NewTestLogger(t)
+	// the test logs write queries as they are
+	settings.logFormat = "plain"
+	return settings
+}
+
+// writeDetectedTestLog writes a test log whose format is detected from its banner.
+func writeDetectedTestLog(t *testing.T, logs []string) Settings {
+	settings := writeTestLog(t, logs)
+	settings.logFormat = autoLogFormat
+	return settings
+}
@@ -0,0 +1,334 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// TestMarkerAction is what a marker query tells about the tests around it.
+type TestMarkerAction string
+
+const (
+	MarkClassStart TestMarkerAction = "class-start"
+	MarkClassEnd   TestMarkerAction = "class-end"
+	MarkTestStart  TestMarkerAction = "test-start"
+	MarkTestEnd    TestMarkerAction = "test-end"
+	// MarkPhase moves the current test, or the current class outside of tests, to another phase
+	MarkPhase TestMarkerAction = "phase"
+)
+
+// TestPhase is the part of a test, or of a test class, that a query ran in. It's empty when the markers don't tell.
+type TestPhase string
+
+const (
+	PhaseClassSetup    TestPhase = "class-setup"
+	PhaseSetup         TestPhase = "setup"
+	PhaseCall          TestPhase = "call"
+	PhaseTeardown      TestPhase = "teardown"
+	PhaseClassTeardown TestPhase = "class-teardown"
+)
+
+// TestMarker recognizes the queries that test instrumentation sends to mark where tests start and end.
+type TestMarker struct {
+	Action TestMarkerAction `json:"action"`
+	// Pattern is matched against the query text. Its "id" group, or its first group if it has no "id" group,
+	// is the id of the test or class. A "phase" group is the phase of phase markers that don't set Phase,
+	// a "worker" group is the test worker, for runners whose workers use more than one connection.
+	Pattern string `json:"pattern"`
+	// Phase is the phase of the queries from the marker on, for class-start, test-start and phase markers
+	Phase TestPhase `json:"phase"`
+
+	regex *regexp.Regexp
+}
+
+// testMarkerPresets are the markers of the instrumentation we know, by name
+var testMarkerPresets = map[string][]TestMarker{
+	// select 'dolt: setUp, test id = ...' queries, the setUp marker doesn't tell the setup from the test itself
+	"nautobot": {
+		{Action: MarkTestStart, Pattern: testStartingRegex},
+		{Action: MarkTestEnd, Pattern: testFinishedRegex},
+	},
+	// /* test:setup app.tests.SomeTestCase.test_one */ tags, alone or in a comment of any query, followed by
+	// worker=gw0 for parallel runs
+	"comment": {
+		{Action: MarkClassStart, Pattern: commentMarkerRegex("class-setup"), Phase: PhaseClassSetup},
+		{Action: MarkPhase, Pattern: commentMarkerRegex("class-teardown"), Phase: PhaseClassTeardown},
+		{Action: MarkClassEnd, Pattern: commentMarkerRegex("class-end")},
+		{Action: MarkTestStart, Pattern: commentMarkerRegex("setup"), Phase: PhaseSetup},
+		{Action: MarkPhase, Pattern: commentMarkerRegex("call"), Phase: PhaseCall},
+		{Action: MarkPhase, Pattern: commentMarkerRegex("teardown"), Phase: PhaseTeardown},
+		{Action: MarkTestEnd, Pattern: commentMarkerRegex("end")},
+	},
+	// SET @test_id = '...' and SET @test_phase = '...', set to NULL when the test or class ends
+	"variable": {
+		{Action: MarkClassEnd, Pattern: variableMarkerEndRegex("test_class")},
+		{Action: MarkClassStart, Pattern: variableMarkerRegex("test_class", "id"), Phase: PhaseClassSetup},
+		{Action: MarkTestEnd, Pattern: variableMarkerEndRegex("test_id")},
+		{Action: MarkTestStart, Pattern: variableMarkerRegex("test_id", "id"), Phase: PhaseSetup},
+		{Action: MarkPhase, Pattern: variableMarkerRegex("test_phase", "phase")},
+	},
+}
+
+// defaultTestMarkers are the markers used unless configured otherwise
+var defaultTestMarkers = []string{"nautobot"}
+
+// loadTestMarkers returns the markers of the given presets and marker files. Marker files hold a JSON list of markers.
+func loadTestMarkers(sources []string) ([]TestMarker, error) {
+	markers := []TestMarker{}
+	for _, source := range sources {
+		if preset, ok := testMarkerPresets[source]; ok {
+			markers = append(markers, preset...)
+			continue
+		}
+		data, err := os.ReadFile(source)
+		if err != nil {
+			return nil, fmt.Errorf("test markers %s are neither a preset nor a readable file: %w", source, err)
+		}
+		var fileMarkers []TestMarker
+		if err := json.Unmarshal(data, &fileMarkers); err != nil {
+			return nil, fmt.Errorf("reading test markers %s: %w", source, err)
+		}
+		markers = append(markers, fileMarkers...)
+	}
+	for i := range markers {
+		if err := markers[i].compile(); err != nil {
+			return nil, err
+		}
+	}
+	return markers, nil
+}
+
+func (m *TestMarker) compile() error {
+	switch m.Action {
+	case MarkClassStart, MarkClassEnd, MarkTestStart, MarkTestEnd, MarkPhase:
+	default:
+		return fmt.Errorf("unknown test marker action %q", m.Action)
+	}
+	regex, err := regexp.Compile(m.Pattern)
+	if err != nil {
+		return fmt.Errorf("test marker %q: %w", m.Pattern, err)
+	}
+	if m.Action == MarkPhase && m.Phase == "" && regex.SubexpIndex("phase") < 0 {
+		return fmt.Errorf("phase test marker %q needs a phase or a phase group", m.Pattern)
+	}
+	m.regex = regex
+	return nil
+}
+
+// testMarkerMatch is what a marker query tells.
+type testMarkerMatch struct {
+	Id    string
+	Phase TestPhase
+	// Worker is the test worker that sent the marker, if the marker tells
+	Worker string
+}
+
+// match returns what the query marks, and whether the query is a marker at all.
+func (m *TestMarker) match(query string) (testMarkerMatch, bool) {
+	match := m.regex.FindStringSubmatch(query)
+	if match == nil {
+		return testMarkerMatch{}, false
+	}
+	result := testMarkerMatch{Phase: m.Phase}
+	if index := m.regex.SubexpIndex("id"); index >= 0 {
+		result.Id = match[index]
+	} else if len(match) > 1 {
+		result.Id = match[1]
+	}
+	if index := m.regex.SubexpIndex("phase"); result.Phase == "" && index >= 0 {
+		result.Phase = TestPhase(match[index])
+	}
+	if index := m.regex.SubexpIndex("worker"); index >= 0 {
+		result.Worker = match[index]
+	}
+	return result, true
+}
+
+// testScope is the test, or the test class outside of tests, that a query ran in.
+type testScope struct {
+	TestId  string
+	ClassId string
+	Phase   TestPhase
+}
+
+// UnmarkedConnectionPolicy tells which test the queries of connections that never sent a marker belong to.
+type UnmarkedConnectionPolicy string
+
+const (
+	// UnmarkedNone leaves their queries unattributed
+	UnmarkedNone UnmarkedConnectionPolicy = "none"
+	// UnmarkedSingle attributes their queries to the test that's running if there's only one, e.g. for a serial
+	// test run with helper connections
+	UnmarkedSingle UnmarkedConnectionPolicy = "single"
+	// UnmarkedLatest attributes their queries to the test that started last on any connection
+	UnmarkedLatest UnmarkedConnectionPolicy = "latest"
+)
+
+var unmarkedConnectionPolicies = []UnmarkedConnectionPolicy{UnmarkedNone, UnmarkedSingle, UnmarkedLatest}
+
+func parseUnmarkedConnectionPolicy(name string) (UnmarkedConnectionPolicy, error) {
+	for _, policy := range unmarkedConnectionPolicies {
+		if string(policy) == name {
+			return policy, nil
+		}
+	}
+	return "", fmt.Errorf("unknown unmarked connection policy %s", name)
+}
+
+// testState is where the markers of a connection, or of a test worker, are at.
+type testState struct {
+	classId    string
+	classPhase TestPhase
+	testId     string
+	phase      TestPhase
+}
+
+// testTracker follows the marker queries to tell which test each query ran in. Parallel test runners run tests
+// on several connections at once, so the tests are followed for each connection, or for each worker when the
+// markers tell the worker, which may use several connections. Test and class ids of queries that ran in a class
+// but outside of its tests are the class id.
+type testTracker struct {
+	markers  []TestMarker
+	unmarked UnmarkedConnectionPolicy
+	// states are keyed by connection, or by worker for the connections whose markers tell the worker
+	states  map[string]*testState
+	workers map[int]string
+	// latest is the state that started a test or a class last
+	latest *testState
+}
+
+func newTestTracker(markers []TestMarker, unmarked UnmarkedConnectionPolicy) *testTracker {
+	return &testTracker{
+		markers:  markers,
+		unmarked: unmarked,
+		states:   map[string]*testState{},
+		workers:  map[int]string{},
+	}
+}
+
+func (t *testTracker) stateKey(connectionId int) string {
+	if worker, ok := t.workers[connectionId]; ok {
+		return "worker " + worker
+	}
+	return fmt.Sprintf("conn %d", connectionId)
+}
+
+// process returns the scope of a query of the connection, after the query is applied if it's a marker.
+// Start and phase markers are in the scope they start, end markers are outside of the scope they end.
+// Markers that don't match the current test or class of their connection are described in warnings.
+func (t *testTracker) process(connectionId int, query string) (scope testScope, warnings []string) {
+	for i := range t.markers {
+		marker := &t.markers[i]
+		match, ok := marker.match(query)
+		if !ok {
+			continue
+		}
+		if match.Worker != "" {
+			t.workers[connectionId] = match.Worker
+		}
+		key := t.stateKey(connectionId)
+		state, ok := t.states[key]
+		if !ok {
+			state = &testState{}
+			t.states[key] = state
+		}
+		warnings = state.apply(marker.Action, match)
+		if marker.Action == MarkClassStart || marker.Action == MarkTestStart {
+			t.latest = state
+		}
+		break
+	}
+	return t.scope(connectionId), warnings
+}
+
+// scope returns the scope the queries of the connection are in at this point of the log.
+func (t *testTracker) scope(connectionId int) testScope {
+	if state, ok := t.states[t.stateKey(connectionId)]; ok {
+		return state.scope()
+	}
+	switch t.unmarked {
+	case UnmarkedLatest:
+		if t.latest != nil {
+			return t.latest.scope()
+		}
+	case UnmarkedSingle:
+		var running []testScope
+		for _, state := range t.states {
+			if scope := state.scope(); scope.TestId != "" {
+				running = append(running, scope)
+			}
+		}
+		if len(running) == 1 {
+			return running[0]
+		}
+	}
+	return testScope{}
+}
+
+// connectionClosed forgets the state of the connection, the state of its worker is kept for its other connections.
+func (t *testTracker) connectionClosed(connectionId int) {
+	if _, ok := t.workers[connectionId]; ok {
+		delete(t.workers, connectionId)
+		return
+	}
+	key := t.stateKey(connectionId)
+	if t.latest == t.states[key] {
+		t.latest = nil
+	}
+	delete(t.states, key)
+}
+
+func (s *testState) apply(action TestMarkerAction, match testMarkerMatch) (warnings []string) {
+	id, phase := match.Id, match.Phase
+	switch action {
+	case MarkClassStart:
+		if s.testId != "" {
+			warnings = append(warnings, fmt.Sprintf("class %s started during test %s", id, s.testId))
+			s.testId, s.phase = "", ""
+		}
+		s.classId, s.classPhase = id, phase
+	case MarkClassEnd:
+		if id != "" && id != s.classId {
+			warnings = append(warnings, fmt.Sprintf("test class id mismatch: %s (this class) != %s (last started class)",
+				id, s.classId))
+		}
+		s.classId, s.classPhase, s.testId, s.phase = "", "", "", ""
+	case MarkTestStart:
+		s.testId, s.phase = id, phase
+	case MarkTestEnd:
+		if id != s.testId {
+			warnings = append(warnings, fmt.Sprintf("test id mismatch: %s (this test) != %s (last started test)",
+				id, s.testId))
+		}
+		s.testId, s.phase = "", ""
+	case MarkPhase:
+		if id != "" && id != s.testId && id != s.classId {
+			warnings = append(warnings, fmt.Sprintf("phase %s of %s outside of it", phase, id))
+		}
+		if s.testId != "" {
+			s.phase = phase
+		} else {
+			s.classPhase = phase
+		}
+	}
+	return warnings
+}
+
+func (s *testState) scope() testScope {
+	if s.testId != "" {
+		return testScope{TestId: s.testId, ClassId: s.classId, Phase: s.phase}
+	}
+	if s.classId != "" {
+		return testScope{TestId: s.classId, ClassId: s.classId, Phase: s.classPhase}
+	}
+	return testScope{}
+}
+
+// testMarkersUsage describes the presets for the -test-markers flag.
+func testMarkersUsage() string {
+	return fmt.Sprintf("Comma-separated test marker presets (%s) or JSON files of markers, "+
+		"telling which queries mark where tests start and end", strings.Join(sortedKeys(testMarkerPresets), ", "))
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// mysqldBannerKey is the key of the banner that mysqld prints at the top of its logs, its value is the version
+const mysqldBannerKey = "mysqld"
+
+// mysqlLogFormat reads the general query log and the slow query log of MySQL servers, to compare dolt with the
+// MySQL server running the same tests. The layout of a record, general or slow, is detected for every record.
+//
+// The general log has a line per command, queries are logged when they're received and have no duration:
+//
+//	2023-03-22T21:54:43.123456Z	   12 Connect	root@localhost on nautobot using TCP/IP
+//	2023-03-22T21:54:43.123789Z	   12 Query	SELECT 1
+//
+// The slow log has a block per query, written when it finished:
+//
+//	# Time: 2023-03-22T21:54:43.123456Z
+//	# User@Host: root[root] @ localhost [127.0.0.1]  Id:    12
+//	# Query_time: 0.000215  Lock_time: 0.000002 Rows_sent: 1  Rows_examined: 0
+//	use nautobot;
+//	SET timestamp=1679522083;
+//	SELECT 1;
+type mysqlLogFormat struct{}
+
+func (mysqlLogFormat) Name() string {
+	return "mysql"
+}
+
+func (mysqlLogFormat) Description() string {
+	return "MySQL general query log or slow query log"
+}
+
+func (mysqlLogFormat) Detect(banner ServerBanner) bool {
+	return banner.Has(mysqldBannerKey)
+}
+
+func (mysqlLogFormat) MultiLine() bool {
+	return true
+}
+
+func (mysqlLogFormat) DecodeQuery(query string) (string, error) {
+	return query, nil
+}
+
+func (mysqlLogFormat) IsRecordStart(line string) bool {
+	return isMysqlGeneralRecordStart(line) || isMysqlSlowRecordStart(line)
+}
+
+// ContinuesRecord continues general log records until the next record, and slow log records until the next
+// # Time: or # User@Host: line that doesn't follow a # Time: line.
+func (mysqlLogFormat) ContinuesRecord(entry string, line string) bool {
+	if !strings.HasPrefix(entry, "# ") {
+		return !isMysqlGeneralRecordStart(line)
+	}
+	if strings.HasPrefix(line, "# User@Host:") {
+		return strings.HasPrefix(entry, "# Time:") && !strings.Contains(entry, "\n")
+	}
+	return !strings.HasPrefix(line, "# Time:")
+}
+
+func (mysqlLogFormat) ParseRecord(lineNumber int, entry string) (LogRecord, bool) {
+	if strings.HasPrefix(entry, "# ") {
+		return parseMysqlSlowRecord(lineNumber, entry)
+	}
+	return parseMysqlGeneralRecord(lineNumber, entry)
+}
+
+func isMysqlGeneralRecordStart(line string) bool {
+	return !strings.Contains(line, "\n") && RegexSplit(line, mysqlGeneralLogRegex) != nil
+}
+
+func isMysqlSlowRecordStart(line string) bool {
+	return strings.HasPrefix(line, "# Time:") || strings.HasPrefix(line, "# User@Host:")
+}
+
+// parseMysqlGeneralRecord parses a general log record. Queries and executed prepared statements are finished
+// queries without a duration, the other commands besides Connect and Quit aren't needed.
+func parseMysqlGeneralRecord(lineNumber int, entry string) (LogRecord, bool) {
+	record := LogRecord{LineNumber: lineNumber}
+	generalParse := RegexSplit(entry, mysqlGeneralLogRegex)
+	if generalParse == nil {
+		return record, false
+	}
+	if generalParse[0] != "" {
+		timestamp, err := parseMysqlTime(generalParse[0])
+		if err != nil {
+			return record, false
+		}
+		record.Timestamp = timestamp
+	}
+	connectionId, err := strconv.Atoi(generalParse[1])
+	if err != nil {
+		return record, false
+	}
+	record.ConnectionId = connectionId
+	record.Message = generalParse[2]
+	argument := generalParse[3]
+
+	switch record.Message {
+	case "Query", "Execute":
+		record.Kind = RecordQueryFinished
+		record.Fields = LogFields{{Key: "query", Value: argument}}
+	case "Connect":
+		record.Kind = RecordConnectionOpened
+		if connectParse := RegexSplit(argument, mysqlConnectRegex); connectParse != nil {
+			record.Fields = LogFields{{Key: "connectionDb", Value: connectParse[0]}}
+		}
+	case "Quit":
+		record.Kind = RecordConnectionClosed
+	}
+	return record, true
+}
+
+// parseMysqlSlowRecord parses a slow log record. Statements that failed are errored queries when the server writes
+// their error number, with log_slow_extra.
+func parseMysqlSlowRecord(lineNumber int, entry string) (LogRecord, bool) {
+	record := LogRecord{LineNumber: lineNumber, Message: "Query"}
+	lines := strings.Split(entry, "\n")
+	connectionFound := false
+	errno := ""
+	for len(lines) > 0 && strings.HasPrefix(lines[0], "# ") {
+		line := lines[0]
+		lines = lines[1:]
+		switch {
+		case strings.HasPrefix(line, "# Time: "):
+			timestamp, err := parseMysqlTime(strings.TrimPrefix(line, "# Time: "))
+			if err != nil {
+				return record, false
+			}
+			record.Timestamp = timestamp
+		case strings.HasPrefix(line, "# User@Host: "):
+			userHostParse := RegexSplit(line, mysqlSlowUserHostRegex)
+			if userHostParse == nil {
+				return record, false
+			}
+			record.ConnectionId, _ = strconv.Atoi(userHostParse[0])
+			connectionFound = true
+		case strings.HasPrefix(line, "# Query_time: "):
+			if queryTimeParse := RegexSplit(line, mysqlSlowQueryTimeRegex); queryTimeParse != nil {
+				seconds, _ := strconv.ParseFloat(queryTimeParse[0], 64)
+				record.Duration = time.Duration(seconds * float64(time.Second))
+			}
+			if errnoParse := RegexSplit(line, mysqlSlowErrnoRegex); errnoParse != nil && errnoParse[0] != "0" {
+				errno = errnoParse[0]
+			}
+		}
+	}
+	if !connectionFound {
+		return record, false
+	}
+
+	// the database is only written when it changed, the statement's start time always is
+	fields := LogFields{}
+	for len(lines) > 0 {
+		if useParse := RegexSplit(lines[0], mysqlSlowUseRegex); useParse != nil {
+			fields = append(fields, LogField{Key: "connectionDb", Value: useParse[0]})
+			lines = lines[1:]
+			continue
+		}
+		if timestampParse := RegexSplit(lines[0], mysqlSlowTimestampRegex); timestampParse != nil {
+			if record.Timestamp.IsZero() {
+				seconds, _ := strconv.ParseInt(timestampParse[0], 10, 64)
+				record.Timestamp = time.Unix(seconds, 0).UTC()
+			}
+			lines = lines[1:]
+		}
+		break
+	}
+	query := strings.TrimSuffix(strings.Join(lines, "\n"), ";")
+	// with log_slow_admin_statements, commands like Quit are written as comments
+	if query == "" || strings.HasPrefix(query, "# administrator command: ") {
+		return record, true
+	}
+
+	record.Kind = RecordQueryFinished
+	if errno != "" {
+		record.Kind = RecordQueryError
+		fields = append(fields, LogField{Key: "error", Value: fmt.Sprintf("Errno: %s", errno)})
+	}
+	record.Fields = append(fields, LogField{Key: "query", Value: query})
+	return record, true
+}
+
+// parseMysqlTime parses the times of current MySQL releases, 2023-03-22T21:54:43.123456Z, and the ones of
+// releases before 5.7, 230322 21:54:43 with the hour padded with a space.
+func parseMysqlTime(text string) (time.Time, error) {
+	if timestamp, err := time.Parse(time.RFC3339Nano, text); err == nil {
+		return timestamp, nil
+	}
+	return time.Parse("060102 15:04:05", strings.Replace(text, "  ", " 0", 1))
+}
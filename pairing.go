@@ -0,0 +1,147 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+type PairingIssueKind int
+
+const (
+	// QueryNeverFinished is a "Starting query" that wasn't followed by a matching
+	// "Query finished" or "error running query" on the same connection.
+	QueryNeverFinished PairingIssueKind = iota
+	// QueryFinishedWithoutStart is a "Query finished" or "error running query" without a matching "Starting query".
+	QueryFinishedWithoutStart
+	// QueryRunningAtConnectionClose is a "Starting query" that was still pending when its connection was closed.
+	QueryRunningAtConnectionClose
+)
+
+func (k PairingIssueKind) String() string {
+	switch k {
+	case QueryNeverFinished:
+		return "started but never finished"
+	case QueryFinishedWithoutStart:
+		return "finished without start"
+	case QueryRunningAtConnectionClose:
+		return "running when connection closed"
+	default:
+		return fmt.Sprintf("unknown pairing issue %d", int(k))
+	}
+}
+
+type PairingIssue struct {
+	Kind   PairingIssueKind
+	Record LogRecord
+	// Text is the decoded query text
+	Text   string
+	TestId string
+	// ClosedLineNumber is the line of the ConnectionClosed record for QueryRunningAtConnectionClose issues
+	ClosedLineNumber int
+}
+
+func (i *PairingIssue) String(lines *LogLines) string {
+	sb := strings.Builder{}
+	sb.WriteString(fmt.Sprintf("Line %s, connection %d: query %s\n", lines.Location(i.Record.LineNumber), i.Record.ConnectionId, i.Kind))
+	sb.WriteString(fmt.Sprintf("Time: %s\n", i.Record.Timestamp.Format(time.RFC3339)))
+	if i.ClosedLineNumber != 0 {
+		sb.WriteString(fmt.Sprintf("Connection closed on line %s\n", lines.Location(i.ClosedLineNumber)))
+	}
+	if i.TestId != "" {
+		sb.WriteString(fmt.Sprintf("Test: %s / %s\n", i.TestId, PyTestNameFromTestId(i.TestId)))
+	}
+	sb.WriteString(fmt.Sprintf("Query:\n%s\n", i.Text))
+	return sb.String()
+}
+
+func isPairingIssueKind(kind PairingIssueKind) func(PairingIssue) bool {
+	return func(issue PairingIssue) bool {
+		return issue.Kind == kind
+	}
+}
+
+type pendingQuery struct {
+	record LogRecord
+	text   string
+	testId string
+}
+
+// queryPairer matches "Starting query" records with the record that completes them.
+// Dolt runs the queries of a connection one at a time, so the next completion logged
+// on a connection should be for the last query started on it.
+type queryPairer struct {
+	pending map[int]pendingQuery
+	issues  []PairingIssue
+}
+
+func newQueryPairer() *queryPairer {
+	return &queryPairer{
+		pending: make(map[int]pendingQuery),
+		issues:  make([]PairingIssue, 0),
+	}
+}
+
+func (p *queryPairer) start(record LogRecord, text string, testId string) {
+	p.abandon(record.ConnectionId)
+	p.pending[record.ConnectionId] = pendingQuery{record: record, text: text, testId: testId}
+}
+
+// finish returns the "Starting query" record that the given completion record belongs to, if there is one.
+func (p *queryPairer) finish(record LogRecord, text string, testId string) (LogRecord, bool) {
+	started, ok := p.pending[record.ConnectionId]
+	if ok && started.record.Query() == record.Query() {
+		delete(p.pending, record.ConnectionId)
+		return started.record, true
+	}
+
+	p.abandon(record.ConnectionId)
+	p.issues = append(p.issues, PairingIssue{
+		Kind:   QueryFinishedWithoutStart,
+		Record: record,
+		Text:   text,
+		TestId: testId,
+	})
+	return LogRecord{}, false
+}
+
+func (p *queryPairer) connectionClosed(record LogRecord) {
+	started, ok := p.pending[record.ConnectionId]
+	if !ok {
+		return
+	}
+	delete(p.pending, record.ConnectionId)
+	p.issues = append(p.issues, PairingIssue{
+		Kind:             QueryRunningAtConnectionClose,
+		Record:           started.record,
+		Text:             started.text,
+		TestId:           started.testId,
+		ClosedLineNumber: record.LineNumber,
+	})
+}
+
+// end flags all queries that are still pending at the end of the log and returns all issues in log order.
+func (p *queryPairer) end() []PairingIssue {
+	for connectionId := range p.pending {
+		p.abandon(connectionId)
+	}
+	sort.SliceStable(p.issues, func(i, j int) bool {
+		return p.issues[i].Record.LineNumber < p.issues[j].Record.LineNumber
+	})
+	return p.issues
+}
+
+func (p *queryPairer) abandon(connectionId int) {
+	started, ok := p.pending[connectionId]
+	if !ok {
+		return
+	}
+	delete(p.pending, connectionId)
+	p.issues = append(p.issues, PairingIssue{
+		Kind:   QueryNeverFinished,
+		Record: started.record,
+		Text:   started.text,
+		TestId: started.testId,
+	})
+}
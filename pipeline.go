@@ -0,0 +1,169 @@
+package main
+
+import (
+	"github.com/dolthub/go-mysql-server/sql"
+	"github.com/dolthub/go-mysql-server/sql/parse"
+	"io"
+	"runtime"
+	"sync"
+	"time"
+)
+
+// The log is parsed in stages: one goroutine reads and classifies the lines, a pool of workers decodes and parses
+// the queries, and the caller aggregates the results. Aggregation is the only stage that depends on the lines before
+// it (test attribution, pairing, sessions, transactions), so it stays sequential and gets the results in line order.
+
+// parsedLine is a line of the log once it went through the reading, classification and parsing stages.
+type parsedLine struct {
+	LineNumber int
+	// TooLong is set for lines longer than the maximum line length, nothing else is set for them
+	TooLong bool
+	Record  LogRecord
+	// Query is the decoded query text, set for records that have one
+	Query string
+	// Unpaired is set for the records of formats that log a query once, they have no start to pair with
+	Unpaired bool
+
+	// The rest is only set for finished and errored queries
+	Node           sql.Node
+	ParseError     error
+	NodeDebug      string
+	Normalized     string
+	NormalizeError error
+	Fingerprint    Fingerprint
+}
+
+// defaultParseWorkers is the number of workers in the parse stage unless configured otherwise
+var defaultParseWorkers = runtime.NumCPU()
+
+// logPipeline runs the reading, classification and parsing stages over a log.
+type logPipeline struct {
+	// results has one channel per classified line, in line order, each receiving that line once it's parsed
+	results chan chan parsedLine
+	err     error
+	// formats are the formats the records of the log were read in, in order
+	formats []LogFormat
+}
+
+// parseLogLines starts the pipeline over the input, in the given format or the detected one if format is nil.
+// The results must be read until the channel is closed, err is set after that if reading the input failed.
+func parseLogLines(input io.Reader, maxLineLength int, workers int, format LogFormat) *logPipeline {
+	if workers < 1 {
+		workers = 1
+	}
+	pipeline := &logPipeline{results: make(chan chan parsedLine, workers*64)}
+	jobs := make(chan func(*sql.Context), workers*64)
+
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			// contexts aren't safe to share between goroutines, each worker gets its own
+			ctx := sql.NewEmptyContext()
+			for job := range jobs {
+				job(ctx)
+			}
+		}()
+	}
+
+	go func() {
+		defer func() {
+			close(jobs)
+			wg.Wait()
+			close(pipeline.results)
+		}()
+		reader := newLogEntryReader(NewLineReader(input, maxLineLength), maxLineLength, format)
+		var lastTimestamp time.Time
+		for reader.Next() {
+			entry := reader.Entry()
+			lineNumber := entry.LineNumber
+			result := make(chan parsedLine, 1)
+			if entry.TooLong {
+				result <- parsedLine{LineNumber: lineNumber, TooLong: true}
+				pipeline.results <- result
+				continue
+			}
+			record, ok := parseLogEntry(entry)
+			// mysqld leaves out the time of records logged in the same second as the record before
+			if ok && record.Timestamp.IsZero() {
+				record.Timestamp = lastTimestamp
+			} else if ok {
+				lastTimestamp = record.Timestamp
+			}
+			_, unpaired := entry.Format.(recordLogFormat)
+			if ok && (len(pipeline.formats) == 0 || pipeline.formats[len(pipeline.formats)-1] != entry.Format) {
+				pipeline.formats = append(pipeline.formats, entry.Format)
+			}
+			if !ok || !recordNeedsParsing(record) {
+				if ok {
+					result <- parsedLine{LineNumber: lineNumber, Record: record, Unpaired: unpaired}
+					pipeline.results <- result
+				}
+				continue
+			}
+			pipeline.results <- result
+			jobs <- func(ctx *sql.Context) {
+				line := parseLogRecordQuery(ctx, record, entry.Format)
+				line.Unpaired = unpaired
+				result <- line
+			}
+		}
+		pipeline.err = reader.Err()
+	}()
+
+	return pipeline
+}
+
+// parseLogEntry tokenizes an entry of a dolt log, or parses it in the format it was read in if that isn't a dolt log.
+func parseLogEntry(entry logEntry) (LogRecord, bool) {
+	if format, ok := entry.Format.(recordLogFormat); ok {
+		return format.ParseRecord(entry.LineNumber, entry.Text)
+	}
+	return parseLogLine(entry.LineNumber, entry.Text, encodesQueries(entry.Format))
+}
+
+// recordNeedsParsing returns whether the record carries a query that the parse stage has work to do for.
+func recordNeedsParsing(record LogRecord) bool {
+	switch record.Kind {
+	case RecordQueryStarted, RecordQueryFinished, RecordQueryError:
+		return record.Query() != ""
+	default:
+		return false
+	}
+}
+
+// parseLogRecordQuery is the parse stage: it decodes the query of the record and parses it, without depending
+// on any other line of the log.
+func parseLogRecordQuery(ctx *sql.Context, record LogRecord, format LogFormat) parsedLine {
+	query, decodeErr := format.DecodeQuery(record.Query())
+	line := parsedLine{
+		LineNumber: record.LineNumber,
+		Record:     record,
+		Query:      query,
+	}
+	if record.Kind == RecordQueryStarted {
+		// starting records are only used to pair queries
+		return line
+	}
+
+	line.Fingerprint = NewFingerprint(line.Query)
+	// a query that can't be decoded is reported like one that can't be parsed, rather than parsed as it is
+	if decodeErr != nil {
+		line.ParseError = decodeErr
+		return line
+	}
+	node, err := parse.Parse(ctx, line.Query)
+	if err != nil {
+		line.ParseError = err
+		return line
+	}
+	line.Node = node
+	line.NodeDebug = debugString(node)
+	if normalized, err := DropExtraneousData(node); err != nil {
+		line.NormalizeError = err
+	} else {
+		line.Normalized = debugString(normalized)
+	}
+	return line
+}
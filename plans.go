@@ -0,0 +1,189 @@
+package main
+
+import (
+	"fmt"
+	"github.com/dolthub/go-mysql-server/sql"
+	"github.com/dolthub/go-mysql-server/sql/parse"
+	"github.com/dolthub/go-mysql-server/sql/plan"
+	"github.com/dolthub/go-mysql-server/sql/transform"
+	"sort"
+	"strings"
+	"time"
+)
+
+// QueryPlan is the plan that go-mysql-server's analyzer builds for a query shape, against the reconstructed schema
+// as the first query of the shape saw it.
+type QueryPlan struct {
+	Fingerprint Fingerprint
+	// LineNumber and Query are the line and the text of the query that was analyzed
+	LineNumber int
+	Query      string
+	// Node is the analyzed plan, nil if the analyzer failed
+	Node sql.Node
+	// Explain is the plan the way EXPLAIN prints it
+	Explain string
+	// TableScans are the tables the plan reads in full rather than through an index
+	TableScans []string
+	// Joins are the join operators of the plan, e.g. LookupJoin or InnerJoin
+	Joins []string
+	// NestedLoopJoins counts the joins that neither look up, hash nor merge, they read their right side for every row
+	// of their left side
+	NestedLoopJoins int
+	// Error is the error the analyzer returned, e.g. for a table that the schema doesn't have
+	Error string
+	// Queries is the number of queries of the shape, Duration the time they took in total
+	Queries  int
+	Duration time.Duration
+}
+
+func (p *QueryPlan) String(lines *LogLines) string {
+	sb := strings.Builder{}
+	sb.WriteString(fmt.Sprintf("Fingerprint %s, %d queries, %s in total\n", p.Fingerprint.Id, p.Queries, p.Duration))
+	sb.WriteString(fmt.Sprintf("%s\n", p.Fingerprint.Text))
+	sb.WriteString(fmt.Sprintf("Analyzed the query on line %s:\n%s\n", lines.Location(p.LineNumber), p.Query))
+	if p.Error != "" {
+		sb.WriteString(fmt.Sprintf("Error: %s\n", p.Error))
+		return sb.String()
+	}
+	if len(p.TableScans) > 0 {
+		sb.WriteString(fmt.Sprintf("Full table scans: %s\n", strings.Join(p.TableScans, ", ")))
+	}
+	if len(p.Joins) > 0 {
+		sb.WriteString(fmt.Sprintf("Joins: %s, %d nested loop\n", strings.Join(p.Joins, ", "), p.NestedLoopJoins))
+	}
+	sb.WriteString(fmt.Sprintf("Plan:\n%s\n", p.Explain))
+	return sb.String()
+}
+
+// queryPlanner analyzes the first query of every shape that reads tables, while the schema changes are replayed.
+type queryPlanner struct {
+	// pending are the queries left to analyze, in line order
+	pending []Query
+	plans   map[string]*QueryPlan
+}
+
+func newQueryPlanner(queries QueryCollection) *queryPlanner {
+	planner := &queryPlanner{
+		pending: []Query{},
+		plans:   map[string]*QueryPlan{},
+	}
+	for id, shapeQueries := range queries.ByFingerprint {
+		first := shapeQueries[0]
+		if first.Node == nil || isSchemaChange(first.Node) || len(getTablesUsed(first.Node)) == 0 {
+			continue
+		}
+		queryPlan := &QueryPlan{
+			Fingerprint: first.Fingerprint,
+			LineNumber:  first.LineNumber,
+			Query:       first.Text,
+			Queries:     len(shapeQueries),
+		}
+		for _, query := range shapeQueries {
+			queryPlan.Duration += query.Duration
+		}
+		planner.plans[id] = queryPlan
+		planner.pending = append(planner.pending, first)
+	}
+	sort.Slice(planner.pending, func(i, j int) bool {
+		return planner.pending[i].LineNumber < planner.pending[j].LineNumber
+	})
+	return planner
+}
+
+// analyzeUntil analyzes the pending queries up to the given line, the catalog has the schema of the lines before.
+func (p *queryPlanner) analyzeUntil(catalog *schemaCatalog, lineNumber int) {
+	for len(p.pending) > 0 && p.pending[0].LineNumber <= lineNumber {
+		query := p.pending[0]
+		p.pending = p.pending[1:]
+
+		database := query.ConnectionDb
+		if query.Session != nil {
+			database = query.Session.Database
+		}
+		queryPlan := p.plans[query.Fingerprint.Id]
+		node, err := catalog.analyze(database, query.Text)
+		if err != nil {
+			queryPlan.Error = err.Error()
+			continue
+		}
+		queryPlan.Node = node
+		queryPlan.Explain = strings.TrimSuffix(node.String(), "\n")
+		queryPlan.TableScans, queryPlan.Joins, queryPlan.NestedLoopJoins = planAccess(node)
+	}
+}
+
+// end returns the plans, the shapes that took the longest first.
+func (p *queryPlanner) end() []QueryPlan {
+	plans := make([]QueryPlan, 0, len(p.plans))
+	for _, queryPlan := range p.plans {
+		plans = append(plans, *queryPlan)
+	}
+	sort.Slice(plans, func(i, j int) bool {
+		if plans[i].Duration != plans[j].Duration {
+			return plans[i].Duration > plans[j].Duration
+		}
+		return plans[i].Fingerprint.Id < plans[j].Fingerprint.Id
+	})
+	return plans
+}
+
+// analyze returns the plan the analyzer builds for the query in the database. The analyzer panics on some queries,
+// which is reported like an error rather than ending the analysis.
+func (c *schemaCatalog) analyze(database string, query string) (node sql.Node, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("analyzer panicked: %v", r)
+		}
+	}()
+	c.ctx.SetCurrentDatabase(database)
+	parsed, err := parse.Parse(c.ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	node, err = c.engine.Analyzer.Analyze(c.ctx, parsed, nil)
+	if err != nil {
+		return nil, err
+	}
+	// the analyzer wraps the plan to track the query's progress, EXPLAIN doesn't show that
+	if queryProcess, ok := node.(*plan.QueryProcess); ok {
+		node = queryProcess.Child()
+	}
+	return node, nil
+}
+
+// planAccess returns the tables that the plan scans in full, and its join operators, subqueries included. Tables
+// read through an index are IndexedTableAccess nodes, so the ResolvedTable nodes left are full scans.
+func planAccess(node sql.Node) (tableScans []string, joins []string, nestedLoopJoins int) {
+	tableScans = []string{}
+	joins = []string{}
+	var inspect func(node sql.Node)
+	inspect = func(node sql.Node) {
+		transform.Inspect(node, func(node sql.Node) bool {
+			switch node := node.(type) {
+			case *plan.ResolvedTable:
+				if node.Name() != "" && (node.Database == nil || node.Database.Name() != "information_schema") {
+					tableScans = append(tableScans, node.Name())
+				}
+			case *plan.JoinNode:
+				joins = append(joins, node.Op.String())
+				if !node.Op.IsLookup() && !node.Op.IsHash() && !node.Op.IsMerge() {
+					nestedLoopJoins++
+				}
+			}
+
+			if expressioner, ok := node.(sql.Expressioner); ok {
+				for _, expr := range expressioner.Expressions() {
+					transform.InspectExpr(expr, func(expr sql.Expression) bool {
+						if subquery, ok := expr.(*plan.Subquery); ok {
+							inspect(subquery.Query)
+						}
+						return false
+					})
+				}
+			}
+			return true
+		})
+	}
+	inspect(node)
+	return tableScans, joins, nestedLoopJoins
+}
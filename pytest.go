@@ -0,0 +1,164 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+)
+
+// pytestStatuses maps the outcomes pytest prints to the statuses of the Django test runner
+var pytestStatuses = map[string]TestStatus{
+	"PASSED":  TestPassed,
+	"FAILED":  TestFailed,
+	"ERROR":   TestErrored,
+	"SKIPPED": TestSkipped,
+	"XFAIL":   TestExpectedFailure,
+	"XPASS":   TestUnexpectedSuccess,
+}
+
+// pytestResultParser collects the test results from the output of pytest, which the Django test runner report
+// parsers don't understand:
+//
+//	tests/test_filters.py::PlatformTestCase::test_napalm_args FAILED                [ 50%]
+//	...
+//	=========================== short test summary info ============================
+//	FAILED tests/test_filters.py::PlatformTestCase::test_napalm_args - AssertionError: 0 != 2
+type pytestResultParser struct {
+	// detected is set once a line only pytest prints is seen
+	detected bool
+	results  []TestResult
+	// messages are the messages of the short test summary, by test id
+	messages map[string]string
+	section  string
+	// sectionFailures are the tracebacks of the FAILURES and ERRORS sections, by the header of the traceback
+	sectionFailures map[string]*TestFailure
+	sectionHeaders  []string
+	current         *TestFailure
+}
+
+func newPytestResultParser() *pytestResultParser {
+	return &pytestResultParser{
+		results:         []TestResult{},
+		messages:        map[string]string{},
+		sectionFailures: map[string]*TestFailure{},
+	}
+}
+
+func (p *pytestResultParser) line(lineNumber int, line string) {
+	if sectionParse := RegexSplit(line, pytestSectionRegex); sectionParse != nil {
+		p.section = sectionParse[0]
+		p.current = nil
+		if p.section == "test session starts" || p.section == "short test summary info" {
+			p.detected = true
+		}
+		return
+	}
+
+	switch p.section {
+	case "FAILURES", "ERRORS":
+		p.failureLine(lineNumber, line)
+		return
+	case "short test summary info":
+		if summaryParse := RegexSplit(line, pytestSummaryRegex); summaryParse != nil {
+			id := TestIdFromPytestNodeId(summaryParse[1])
+			p.setResult(lineNumber, id, pytestStatuses[summaryParse[0]], "")
+			if summaryParse[2] != "" {
+				p.messages[id] = summaryParse[2]
+			}
+		}
+		return
+	}
+
+	if resultParse := RegexSplit(line, pytestResultRegex); resultParse != nil {
+		p.setResult(lineNumber, TestIdFromPytestNodeId(resultParse[0]), pytestStatuses[resultParse[1]], resultParse[2])
+	} else if resultParse := RegexSplit(line, pytestWorkerResultRegex); resultParse != nil {
+		p.setResult(lineNumber, TestIdFromPytestNodeId(resultParse[1]), pytestStatuses[resultParse[0]], "")
+	}
+}
+
+// setResult adds the result of a test, or updates it. pytest reports a test twice when its teardown errors
+// after it passed, the failing outcome is kept.
+func (p *pytestResultParser) setResult(lineNumber int, id string, status TestStatus, reason string) {
+	for i := range p.results {
+		if p.results[i].Id != id {
+			continue
+		}
+		if status.Failed() {
+			p.results[i].Status = status
+		}
+		if reason != "" {
+			p.results[i].Reason = reason
+		}
+		return
+	}
+	p.results = append(p.results, TestResult{
+		Id:         id,
+		Status:     status,
+		Reason:     reason,
+		Order:      len(p.results) + 1,
+		LineNumber: lineNumber,
+	})
+}
+
+// failureLine reads the tracebacks of the FAILURES and ERRORS sections:
+//
+//	_____________________ PlatformTestCase.test_napalm_args ______________________
+//	...
+//	>       self.assertEqual(0, 2)
+//	E       AssertionError: 0 != 2
+//
+//	tests/test_filters.py:2416: AssertionError
+func (p *pytestResultParser) failureLine(lineNumber int, line string) {
+	if headerParse := RegexSplit(line, pytestFailureHeaderRegex); headerParse != nil {
+		header := strings.TrimPrefix(headerParse[0], "ERROR at setup of ")
+		header = strings.TrimPrefix(header, "ERROR at teardown of ")
+		p.current = &TestFailure{LineNumber: lineNumber}
+		p.sectionFailures[header] = p.current
+		p.sectionHeaders = append(p.sectionHeaders, header)
+		return
+	}
+	if p.current == nil {
+		return
+	}
+	if strings.HasPrefix(line, "E ") {
+		exception := strings.TrimSpace(strings.TrimPrefix(line, "E"))
+		if p.current.Exception != "" {
+			exception = p.current.Exception + "\n" + exception
+		}
+		p.current.Exception = exception
+		return
+	}
+	if locationParse := RegexSplit(line, pytestLocationRegex); locationParse != nil {
+		frameLine, _ := strconv.Atoi(locationParse[1])
+		frame := TracebackFrame{File: locationParse[0], Line: frameLine}
+		// frames that call the next one end with the function, the last frame ends with the exception
+		if strings.HasPrefix(locationParse[2], "in ") {
+			frame.Function = strings.TrimPrefix(locationParse[2], "in ")
+		}
+		p.current.Frames = append(p.current.Frames, frame)
+	}
+}
+
+// end returns the results in the order the tests ran, and the failures of the tests that failed or errored.
+func (p *pytestResultParser) end() ([]TestResult, []TestFailure) {
+	failures := []TestFailure{}
+	for _, result := range p.results {
+		if !result.Status.Failed() {
+			continue
+		}
+		failure := TestFailure{Id: result.Id, Status: result.Status, Exception: p.messages[result.Id], LineNumber: result.LineNumber}
+		for _, header := range p.sectionHeaders {
+			// the header is the test's name within its module, e.g. PlatformTestCase.test_napalm_args
+			if result.Id == header || strings.HasSuffix(result.Id, "."+header) {
+				sectionFailure := p.sectionFailures[header]
+				failure.Frames = sectionFailure.Frames
+				failure.LineNumber = sectionFailure.LineNumber
+				if sectionFailure.Exception != "" {
+					failure.Exception = sectionFailure.Exception
+				}
+				break
+			}
+		}
+		failures = append(failures, failure)
+	}
+	return p.results, failures
+}
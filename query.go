@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"github.com/dolthub/go-mysql-server/sql"
 	"strings"
+	"time"
 )
 
 type Query struct {
@@ -15,20 +16,55 @@ type Query struct {
 	TestFailed bool
 	PyTestName string
 	Error      string
+	// ParseError is the error go-mysql-server's parser returned for the query, Node is nil if it's set
+	ParseError string
+
+	// Fingerprint is the normalized shape of the query
+	Fingerprint Fingerprint
+	// NormalizedTree is the debug string of the query's tree with its literals and names dropped,
+	// empty if the tree couldn't be normalized
+	NormalizedTree string
+
+	// Fields taken from the log record that reported the query
+	Timestamp    time.Time
+	Level        string
+	ConnectionId int
+	ConnectTime  time.Time
+	ConnectionDb string
+	// Duration is zero for queries that errored, dolt doesn't log how long those took
+	Duration time.Duration
+
+	// Line and time of the matching "Starting query" record, zero if there wasn't one
+	StartLineNumber int
+	StartTimestamp  time.Time
+
+	// Session is the session state of the connection when the query ran
+	Session *SessionState
+	// TransactionId is the id of the transaction the query ran in, zero if it ran outside of one
+	TransactionId int
+
+	// TestClassId and TestPhase are the test class and the phase of the test the query ran in, if the markers tell
+	TestClassId string
+	TestPhase   TestPhase
+	// TestFailure is the traceback of the test the query ran in, if the test failed
+	TestFailure *TestFailure
 }
 
 type QueryCollection struct {
-	All           []Query
-	TestQueries   []Query
-	ByTestId      map[string][]Query
-	ByDebugString map[string][]Query
+	All         []Query
+	TestQueries []Query
+	ByTestId    map[string][]Query
+	// ByFingerprint groups queries by their fingerprint id
+	ByFingerprint map[string][]Query
+	// ParseFailures are the queries the parser couldn't handle, they're grouped by their textual fingerprint
+	ParseFailures []Query
 }
 
 func NewQueryCollection() QueryCollection {
 	return QueryCollection{
 		All:           make([]Query, 0),
 		ByTestId:      make(map[string][]Query),
-		ByDebugString: make(map[string][]Query),
+		ByFingerprint: make(map[string][]Query),
 	}
 }
 
@@ -38,28 +74,56 @@ func (c *QueryCollection) Add(query Query) {
 		c.TestQueries = append(c.TestQueries, query)
 	}
 	c.ByTestId[query.TestId] = append(c.ByTestId[query.TestId], query)
-	nodeDebugString := sql.DebugString(query.Node)
-	c.ByDebugString[nodeDebugString] = append(c.ByDebugString[nodeDebugString], query)
+	if query.ParseError != "" {
+		c.ParseFailures = append(c.ParseFailures, query)
+	}
+	c.ByFingerprint[query.Fingerprint.Id] = append(c.ByFingerprint[query.Fingerprint.Id], query)
 }
 
-func (q *Query) String(logQueryText bool) string {
+func (q *Query) String(logQueryText bool, lines *LogLines) string {
 	sb := strings.Builder{}
 
-	sb.WriteString(fmt.Sprintf("Line %d\n", q.LineNumber))
+	sb.WriteString(fmt.Sprintf("Line %s\n", lines.Location(q.LineNumber)))
+	if q.StartLineNumber != 0 {
+		sb.WriteString(fmt.Sprintf("Started on line %s\n", lines.Location(q.StartLineNumber)))
+	}
+	sb.WriteString(fmt.Sprintf("Time: %s, connection: %d, database: %s\n",
+		q.Timestamp.Format(time.RFC3339), q.ConnectionId, q.ConnectionDb))
+	if q.Error == "" {
+		sb.WriteString(fmt.Sprintf("Duration: %s\n", q.Duration))
+	}
+	if q.TransactionId != 0 {
+		sb.WriteString(fmt.Sprintf("Transaction: %d\n", q.TransactionId))
+	}
+	if q.Session != nil {
+		sb.WriteString(fmt.Sprintf("Session: %s\n", q.Session))
+	}
 	if q.TestId != "" {
 		if q.TestFailed {
 			sb.WriteString(fmt.Sprintf("FAILED TEST: %s / %s\n", q.TestId, q.PyTestName))
+			if q.TestFailure != nil {
+				sb.WriteString(fmt.Sprintf("Test failure: %s\n", q.TestFailure.Summary()))
+			}
 		} else {
 			sb.WriteString(fmt.Sprintf("Passing test: %s / %s\n", q.TestId, q.PyTestName))
 		}
+		if q.TestPhase != "" {
+			sb.WriteString(fmt.Sprintf("Test phase: %s\n", q.TestPhase))
+		}
 	}
 	if logQueryText {
 		sb.WriteString(fmt.Sprintf("Query:\n%s\n", q.Text))
 	}
-	if q.Node == nil {
+	sb.WriteString(fmt.Sprintf("Fingerprint: %s\n", q.Fingerprint))
+	if q.ParseError != "" {
+		sb.WriteString(fmt.Sprintf("Parse error: %s\n", q.ParseError))
+	} else if q.Node == nil {
 		sb.WriteString("Query tree: nil\n")
 	} else {
-		sb.WriteString(fmt.Sprintf("Query tree:\n%s\n", sql.DebugString(q.Node)))
+		sb.WriteString(fmt.Sprintf("Query tree:\n%s\n", debugString(q.Node)))
+		if q.NormalizedTree != "" {
+			sb.WriteString(fmt.Sprintf("Normalized tree:\n%s\n", q.NormalizedTree))
+		}
 	}
 	if q.Error != "" {
 		sb.WriteString(fmt.Sprintf("Query error: %s\n", q.Error))
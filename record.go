@@ -0,0 +1,310 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/dolthub/go-mysql-server/sql"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// LogRecordKind identifies the event that a dolt sql-server log line describes.
+type LogRecordKind int
+
+const (
+	RecordOther LogRecordKind = iota
+	RecordQueryStarted
+	RecordQueryFinished
+	RecordQueryError
+	RecordConnectionOpened
+	RecordConnectionClosed
+)
+
+func (k LogRecordKind) String() string {
+	switch k {
+	case RecordQueryStarted:
+		return "query started"
+	case RecordQueryFinished:
+		return "query finished"
+	case RecordQueryError:
+		return "query error"
+	case RecordConnectionOpened:
+		return "connection opened"
+	case RecordConnectionClosed:
+		return "connection closed"
+	default:
+		return "other"
+	}
+}
+
+type LogField struct {
+	Key   string
+	Value string
+}
+
+// LogFields is the `{key=value, ...}` bag at the end of a log line, in the order it was logged.
+type LogFields []LogField
+
+func (f LogFields) Get(key string) (string, bool) {
+	for _, field := range f {
+		if field.Key == key {
+			return field.Value, true
+		}
+	}
+	return "", false
+}
+
+func (f LogFields) GetOrEmpty(key string) string {
+	value, _ := f.Get(key)
+	return value
+}
+
+// LogRecord is a single tokenized dolt sql-server log line.
+type LogRecord struct {
+	LineNumber   int
+	Timestamp    time.Time
+	Level        string
+	ConnectionId int
+	Message      string
+	Fields       LogFields
+	Kind         LogRecordKind
+	// Duration is only set for RecordQueryFinished records
+	Duration time.Duration
+}
+
+func (r *LogRecord) Query() string {
+	return r.Fields.GetOrEmpty("query")
+}
+
+func (r *LogRecord) Error() string {
+	return r.Fields.GetOrEmpty("error")
+}
+
+func (r *LogRecord) ConnectionDb() string {
+	return r.Fields.GetOrEmpty("connectionDb")
+}
+
+func (r *LogRecord) ConnectTime() time.Time {
+	connectTime, err := time.Parse(time.RFC3339, r.Fields.GetOrEmpty("connectTime"))
+	if err != nil {
+		return time.Time{}
+	}
+	return connectTime
+}
+
+func (r *LogRecord) IsQuery() bool {
+	switch r.Kind {
+	case RecordQueryStarted, RecordQueryFinished, RecordQueryError:
+		return true
+	default:
+		return false
+	}
+}
+
+// ParseLogRecord tokenizes a line produced by the dolt sql-server text log formatter:
+//
+//	2023-03-22T18:55:23Z DEBUG [conn 2] Query finished in 1 ms {connectTime=2023-03-22T18:55:23Z, connectionDb=, query=SET NAMES utf8mb4}
+//
+// Lines that don't start with a timestamp and a level (e.g. the server banner) are not records.
+func ParseLogRecord(lineNumber int, line string) (LogRecord, bool) {
+	return parseLogRecord(lineNumber, line, false)
+}
+
+// parseLogRecord is ParseLogRecord for a log in a format that encodes queries or not.
+func parseLogRecord(lineNumber int, line string, encodedQueries bool) (LogRecord, bool) {
+	record := LogRecord{LineNumber: lineNumber}
+
+	headerParse := RegexSplit(line, logLineRegex)
+	if headerParse == nil {
+		return record, false
+	}
+	timestamp, err := time.Parse(time.RFC3339, headerParse[0])
+	if err != nil {
+		return record, false
+	}
+	record.Timestamp = timestamp
+	record.Level = headerParse[1]
+	if headerParse[2] != "" {
+		record.ConnectionId, err = strconv.Atoi(headerParse[2])
+		if err != nil {
+			return record, false
+		}
+	}
+
+	message := headerParse[3]
+	if bagStart := strings.Index(message, " {"); bagStart >= 0 && strings.HasSuffix(message, "}") {
+		record.Fields = parseLogFields(message[bagStart+2:len(message)-1], encodedQueries)
+		message = message[:bagStart]
+	}
+	record.Message = message
+	record.classify()
+
+	return record, true
+}
+
+// ParseJSONLogRecord tokenizes a line produced by the logrus JSON formatter, which dolt sql-server uses when
+// it's configured to log JSON. It produces the same record as ParseLogRecord does for the equivalent text line:
+//
+//	{"connectTime":"2023-03-22T18:55:23Z","connectionDb":"","connectionID":2,"level":"debug","msg":"Query finished in 1 ms","query":"SET NAMES utf8mb4","time":"2023-03-22T18:55:23Z"}
+func ParseJSONLogRecord(lineNumber int, line string) (LogRecord, bool) {
+	record := LogRecord{LineNumber: lineNumber}
+
+	var entry map[string]interface{}
+	decoder := json.NewDecoder(strings.NewReader(line))
+	decoder.UseNumber()
+	if err := decoder.Decode(&entry); err != nil {
+		return record, false
+	}
+	timeValue, ok := entry["time"].(string)
+	if !ok {
+		return record, false
+	}
+	timestamp, err := time.Parse(time.RFC3339, timeValue)
+	if err != nil {
+		return record, false
+	}
+	record.Timestamp = timestamp
+	level, ok := entry["level"].(string)
+	if !ok {
+		return record, false
+	}
+	// the text formatter abbreviates warning, records use the text formatter's level names
+	if level == "warning" {
+		level = "warn"
+	}
+	record.Level = strings.ToUpper(level)
+	if connectionId, ok := entry[sql.ConnectionIdLogField]; ok {
+		record.ConnectionId, err = strconv.Atoi(jsonFieldValue(connectionId))
+		if err != nil {
+			return record, false
+		}
+	}
+	record.Message = jsonFieldValue(entry["msg"])
+
+	record.Fields = LogFields{}
+	for _, key := range sortedKeys(entry) {
+		switch key {
+		case "time", "level", "msg", sql.ConnectionIdLogField:
+			continue
+		}
+		record.Fields = append(record.Fields, LogField{Key: key, Value: jsonFieldValue(entry[key])})
+	}
+	record.classify()
+
+	return record, true
+}
+
+// ParseLogLine tokenizes a line of a dolt sql-server log in either format. The format is detected for every line,
+// since the server writes some lines, like its banner, as plain text even when it logs JSON.
+func ParseLogLine(lineNumber int, line string) (LogRecord, bool) {
+	return parseLogLine(lineNumber, line, false)
+}
+
+// parseLogLine is ParseLogLine for a log in a format that encodes queries or not.
+func parseLogLine(lineNumber int, line string, encodedQueries bool) (LogRecord, bool) {
+	if strings.HasPrefix(line, "{") {
+		return ParseJSONLogRecord(lineNumber, line)
+	}
+	return parseLogRecord(lineNumber, line, encodedQueries)
+}
+
+// jsonFieldValue formats a JSON field the way the text formatter would.
+func jsonFieldValue(value interface{}) string {
+	switch value := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return value
+	default:
+		return fmt.Sprint(value)
+	}
+}
+
+// classify sets the kind of the record, and the duration of finished queries, from its message.
+func (r *LogRecord) classify() {
+	message := r.Message
+	switch {
+	case message == "Starting query":
+		r.Kind = RecordQueryStarted
+	case message == "error running query":
+		r.Kind = RecordQueryError
+	case message == "NewConnection":
+		r.Kind = RecordConnectionOpened
+	case message == "ConnectionClosed":
+		r.Kind = RecordConnectionClosed
+	default:
+		if durationParse := RegexSplit(message, queryFinishedMessageRegex); durationParse != nil {
+			r.Kind = RecordQueryFinished
+			ms, err := strconv.ParseInt(durationParse[0], 10, 64)
+			if err == nil {
+				r.Duration = time.Duration(ms) * time.Millisecond
+			}
+		}
+	}
+}
+
+// parseLogFields splits the contents of a `{key=value, ...}` bag. Values are not quoted and may contain
+// ", " themselves (e.g. `error=can't create database test_nautobot; database exists`), so a ", key=" sequence
+// only starts a new field if the key sorts after the previous one, which is the order logrus writes them in.
+// The query is always written last and may contain anything, so its value runs to the end of the bag. Errors often
+// quote the query, so when queries are encoded and can't contain ", query=" the error runs to the last one.
+func parseLogFields(bag string, encodedQueries bool) LogFields {
+	fields := LogFields{}
+	if bag == "" {
+		return fields
+	}
+
+	key, value, _ := strings.Cut(bag, "=")
+	current := LogField{Key: key}
+	rest := value
+	for current.Key != "query" {
+		splitAt := -1
+		if current.Key == "error" && encodedQueries {
+			splitAt = strings.LastIndex(rest, ", query=")
+		} else {
+			searchFrom := 0
+			for {
+				index := strings.Index(rest[searchFrom:], ", ")
+				if index < 0 {
+					break
+				}
+				index += searchFrom
+				nextKey, _, found := strings.Cut(rest[index+2:], "=")
+				if found && isLogFieldKey(nextKey) && nextKey > current.Key {
+					splitAt = index
+					break
+				}
+				searchFrom = index + 2
+			}
+		}
+		if splitAt < 0 {
+			break
+		}
+
+		current.Value = rest[:splitAt]
+		fields = append(fields, current)
+		nextKey, nextRest, _ := strings.Cut(rest[splitAt+2:], "=")
+		current = LogField{Key: nextKey}
+		rest = nextRest
+	}
+	current.Value = rest
+	fields = append(fields, current)
+
+	return fields
+}
+
+func isLogFieldKey(key string) bool {
+	if key == "" {
+		return false
+	}
+	for i, r := range key {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r == '_':
+		case r >= '0' && r <= '9' && i > 0:
+		default:
+			return false
+		}
+	}
+	return true
+}
@@ -1,12 +1,39 @@
 package main
 
-import "regexp"
+import (
+	"regexp"
+	"strings"
+	"sync"
+	"unicode"
+)
 
 var (
 	// 2023-03-22T18:55:23Z DEBUG [conn 2] Query finished in 1 ms {connectTime=2023-03-22T18:55:23Z, connectionDb=, query=SET NAMES utf8mb4}
-	finishedQueryRegex = ".*] Query finished in .*{.*, query=(.*)}"
 	// 2023-03-22T18:55:23Z WARN [conn 2] error running query {connectTime=2023-03-22T18:55:23Z, connectionDb=, error=can't create database test_nautobot; database exists, query=CREATE DATABASE `test_nautobot`}
-	errorQueryRegex = ".*] error running query {.*, error=(.*), query=(.*)}"
+	// the message of a multi-line record spans lines
+	logLineRegex = `(?s)^(\S+) ([A-Z]+) (?:\[conn (\d+)\] )?(.*)$`
+	// Query finished in 1 ms
+	queryFinishedMessageRegex = `^Query finished in (\d+) ms$`
+
+	// /usr/sbin/mysqld, Version: 8.0.32 (MySQL Community Server - GPL). started with:
+	mysqldBannerRegex = `^\S*mysqld(?:\.exe)?, Version: (\S+) .*started with:$`
+	// 2023-03-22T21:54:43.123456Z	   12 Query	SELECT 1
+	// 230322 21:54:43	   12 Query	SELECT 1
+	// 		   12 Query	SELECT 1
+	// the argument of a general log record spans lines, the time is left out when it's the same as on the line before
+	mysqlGeneralLogRegex = `(?s)^([^\t]*)\t+ *(\d+) ([A-Z][A-Za-z ]*?)(?:\t(.*))?$`
+	// root@localhost on nautobot using TCP/IP
+	mysqlConnectRegex = `^.* on (\S*) using `
+	// # User@Host: root[root] @ localhost [127.0.0.1]  Id:    12
+	mysqlSlowUserHostRegex = `^# User@Host: .*\sId:\s*(\d+)$`
+	// # Query_time: 0.000215  Lock_time: 0.000002 Rows_sent: 1  Rows_examined: 0
+	mysqlSlowQueryTimeRegex = `^# Query_time: (\d+(?:\.\d+)?)\s`
+	// Errno: 1146, written with log_slow_extra
+	mysqlSlowErrnoRegex = `\sErrno: (\d+)\b`
+	// use nautobot;
+	mysqlSlowUseRegex = `^use (\S+);$`
+	// SET timestamp=1679522083;
+	mysqlSlowTimestampRegex = `^SET timestamp=(\d+);$`
 
 	// select 'dolt: setUp, test id = nautobot.dcim.tests.test_filters.CableTestCase.test_color'
 	testStartingRegex = "select 'dolt: setUp, test id = (.*)'"
@@ -19,19 +46,65 @@ var (
 	pyTestPatchRegex       = `Sending query: SELECT statement_order, TO_BASE64\(statement\) FROM DOLT_PATCH\('HEAD', 'WORKING', '(.*)'\);`
 	pyTestPatchResultRegex = `Result: \((.*)\)`
 	testIdNameRegex        = `(.*)\.(.*)`
+
+	// SELECT statement_order, TO_BASE64(statement) FROM DOLT_PATCH('HEAD', 'WORKING', 'extras_customfield')
+	doltPatchQueryRegex = `(?i)\bFROM\s+DOLT_PATCH\(\s*'HEAD',\s*'WORKING',\s*'([^']*)'\s*\)`
+
+	// test_description (nautobot.dcim.tests.test_filters.PlatformTestCase) ... ok
+	testResultRegex = `^(\w+) \(([\w.]+)\)(?: \.\.\.(?: (.*))?)?$`
+	// ok (0.012s)
+	testResultDurationRegex = `^(.*) \((\d+(?:\.\d+)?)s\)$`
+	// 0.012s     test_description (nautobot.dcim.tests.test_filters.PlatformTestCase)
+	testDurationRegex = `^(\d+(?:\.\d+)?)s\s+(.*)$`
+	//   File "/source/nautobot/dcim/tests/test_filters.py", line 2416, in test_napalm_args
+	tracebackFrameRegex = `^  File "(.*)", line (\d+), in (.*)$`
+
+	// ============================= test session starts ==============================
+	pytestSectionRegex = `^=+ (.+?) =+$`
+	// tests/test_filters.py::PlatformTestCase::test_description PASSED               [ 50%]
+	pytestResultRegex = `^(\S+::.+?) (PASSED|FAILED|ERROR|SKIPPED|XFAIL|XPASS)(?: \((.*)\))?\s*(?:\[\s*\d+%\])?$`
+	// [gw0] [ 50%] PASSED tests/test_filters.py::PlatformTestCase::test_description
+	pytestWorkerResultRegex = `^\[\w+\] \[\s*\d+%\] (PASSED|FAILED|ERROR|SKIPPED|XFAIL|XPASS) (\S+::.+)$`
+	// FAILED tests/test_filters.py::PlatformTestCase::test_napalm_args - AssertionError: 0 != 2
+	pytestSummaryRegex = `^(PASSED|FAILED|ERROR|XFAIL|XPASS) (\S+::\S+)(?: - (.*))?$`
+	// _____________________ PlatformTestCase.test_napalm_args ______________________
+	pytestFailureHeaderRegex = `^_{3,} (.+) _{3,}$`
+	// tests/test_filters.py:2416: AssertionError
+	pytestLocationRegex = `^(\S+\.py):(\d+): (.*)$`
 )
 
+// compiledRegexes caches the compiled regexes by expression, RegexSplit runs for every line of the log
+var compiledRegexes sync.Map
+
+func compileRegex(exp string) *regexp.Regexp {
+	if regex, ok := compiledRegexes.Load(exp); ok {
+		return regex.(*regexp.Regexp)
+	}
+	regex, _ := compiledRegexes.LoadOrStore(exp, regexp.MustCompile(exp))
+	return regex.(*regexp.Regexp)
+}
+
+// commentMarkerRegex matches a /* test:tag id */ or /* test:tag id worker=name */ comment
+func commentMarkerRegex(tag string) string {
+	return `/\*\s*test:` + regexp.QuoteMeta(tag) + `\s+(?P<id>[^\s*]+)(?:\s+worker=(?P<worker>[^\s*]+))?\s*\*/`
+}
+
+// variableMarkerRegex matches a SET @variable = 'value' statement, capturing the value in the given group
+func variableMarkerRegex(variable string, group string) string {
+	return `(?i)^\s*SET\s+@` + regexp.QuoteMeta(variable) + `\s*=\s*'(?P<` + group + `>[^']*)'`
+}
+
+// variableMarkerEndRegex matches a SET @variable = NULL statement
+func variableMarkerEndRegex(variable string) string {
+	return `(?i)^\s*SET\s+@` + regexp.QuoteMeta(variable) + `\s*=\s*NULL\b`
+}
+
 func RegexSplit(text string, exp string) []string {
-	regex := regexp.MustCompile(exp)
-	matches := regex.FindAllSubmatch([]byte(text), -1)
-	if matches == nil {
+	match := compileRegex(exp).FindStringSubmatch(text)
+	if match == nil {
 		return nil
 	}
-	result := make([]string, len(matches[0])-1)
-	for i := 1; i < len(matches[0]); i++ {
-		result[i-1] = string(matches[0][i])
-	}
-	return result
+	return match[1:]
 }
 
 // PyTest report format
@@ -42,12 +115,48 @@ func RegexSplit(text string, exp string) []string {
 
 func TestIdFromPyTestName(pyTestName string) string {
 	pyTestNameParse := RegexSplit(pyTestName, pyTestNameRegex)
-	testId := pyTestNameParse[1] + "." + pyTestNameParse[0]
-	return testId
+	return testResultId(pyTestNameParse[0], pyTestNameParse[1])
+}
+
+// TestIdFromPytestNodeId returns the dotted test id of a pytest node id:
+// tests/test_filters.py::PlatformTestCase::test_napalm_args is tests.test_filters.PlatformTestCase.test_napalm_args
+func TestIdFromPytestNodeId(nodeId string) string {
+	path, name, _ := strings.Cut(nodeId, "::")
+	module := strings.TrimSuffix(strings.ReplaceAll(path, "/", "."), ".py")
+	if name == "" {
+		return module
+	}
+	return module + "." + strings.ReplaceAll(name, "::", ".")
+}
+
+// PytestNodeIdFromTestId returns the pytest node id of a dotted test id. The id doesn't tell where the module ends,
+// so the module is taken to end before the first class, which starts with an upper case letter, or the test.
+func PytestNodeIdFromTestId(testId string) string {
+	// parameters of parametrized tests can hold dots
+	id, parameters := testId, ""
+	if index := strings.Index(testId, "["); index >= 0 {
+		id, parameters = testId[:index], testId[index:]
+	}
+	parts := strings.Split(id, ".")
+	moduleEnd := len(parts) - 1
+	for i, part := range parts[:len(parts)-1] {
+		if part != "" && unicode.IsUpper(rune(part[0])) {
+			moduleEnd = i
+			break
+		}
+	}
+	if moduleEnd == 0 {
+		return testId
+	}
+	return strings.Join(parts[:moduleEnd], "/") + ".py::" + strings.Join(parts[moduleEnd:], "::") + parameters
 }
 
 func PyTestNameFromTestId(testId string) string {
 	testIdParse := RegexSplit(testId, testIdNameRegex)
+	// ids of other instrumentation don't have to be python names
+	if testIdParse == nil {
+		return testId
+	}
 	pyTestName := testIdParse[1] + " (" + testIdParse[0] + ")"
 	return pyTestName
 }
@@ -0,0 +1,460 @@
+package main
+
+import (
+	"fmt"
+	sqle "github.com/dolthub/go-mysql-server"
+	"github.com/dolthub/go-mysql-server/memory"
+	"github.com/dolthub/go-mysql-server/sql"
+	"github.com/dolthub/go-mysql-server/sql/analyzer"
+	"github.com/dolthub/go-mysql-server/sql/parse"
+	"github.com/dolthub/go-mysql-server/sql/plan"
+	"github.com/dolthub/go-mysql-server/sql/transform"
+	"golang.org/x/exp/slices"
+	"math"
+	"sort"
+	"strings"
+)
+
+// SchemaChange is a statement that changed the schema, from the log or from a DOLT_PATCH result of the test report.
+type SchemaChange struct {
+	// LineNumber is the log line of the statement, or of the DOLT_PATCH query that returned it. It's zero for
+	// DOLT_PATCH results that no query of the log returned, they're applied before the log.
+	LineNumber int
+	// ReportLineNumber is the line of the DOLT_PATCH result in the test report, zero for statements of the log
+	ReportLineNumber int
+	// Database is the database the statement ran in
+	Database string
+	Query    string
+	Node     sql.Node
+	// Error is the error the in-memory catalog returned for the statement, the schema didn't change then
+	Error string
+}
+
+func (c *SchemaChange) String(lines *LogLines) string {
+	sb := strings.Builder{}
+	if c.ReportLineNumber != 0 && c.LineNumber == 0 {
+		sb.WriteString(fmt.Sprintf("Before the log, DOLT_PATCH result on report line %d\n", c.ReportLineNumber))
+	} else if c.ReportLineNumber != 0 {
+		sb.WriteString(fmt.Sprintf("Line %s, DOLT_PATCH result on report line %d\n", lines.Location(c.LineNumber), c.ReportLineNumber))
+	} else {
+		sb.WriteString(fmt.Sprintf("Line %s\n", lines.Location(c.LineNumber)))
+	}
+	sb.WriteString(fmt.Sprintf("Database: %s\n", c.Database))
+	sb.WriteString(fmt.Sprintf("Query:\n%s\n", c.Query))
+	if c.Error != "" {
+		sb.WriteString(fmt.Sprintf("Error: %s\n", c.Error))
+	}
+	return sb.String()
+}
+
+// TableSchema is a table of the reconstructed schema, as it was after a schema change.
+type TableSchema struct {
+	Database string
+	Name     string
+	Schema   sql.Schema
+	// Indexes are the secondary indexes of the table, the primary key is part of the schema
+	Indexes []sql.Index
+	// CreateStatement is the SHOW CREATE TABLE output for the table
+	CreateStatement string
+}
+
+// tableVersion is a table as it was from the line of a schema change on.
+type tableVersion struct {
+	lineNumber int
+	// table is nil from the line the table was dropped on
+	table *TableSchema
+}
+
+// SchemaHistory is the schema that the DDL statements of the log and the DOLT_PATCH results of the report built,
+// applied in log order to an in-memory catalog.
+type SchemaHistory struct {
+	// Changes are the schema changes in the order they were applied
+	Changes []SchemaChange
+	// versions are the versions of every table in the order they were made, by database and table name
+	versions map[string][]tableVersion
+}
+
+// Table returns the table as the query on the given log line saw it, changed by the statements of the lines before.
+func (h *SchemaHistory) Table(database string, name string, lineNumber int) (*TableSchema, bool) {
+	var table *TableSchema
+	for _, version := range h.versions[schemaTableKey(database, name)] {
+		if version.lineNumber >= lineNumber {
+			break
+		}
+		table = version.table
+	}
+	return table, table != nil
+}
+
+// Tables returns the tables that the query on the given log line saw, sorted by database and name.
+func (h *SchemaHistory) Tables(lineNumber int) []*TableSchema {
+	keys := make([]string, 0, len(h.versions))
+	for key := range h.versions {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	tables := []*TableSchema{}
+	for _, key := range keys {
+		versions := h.versions[key]
+		if table, ok := h.Table(versions[0].table.Database, versions[0].table.Name, lineNumber); ok {
+			tables = append(tables, table)
+		}
+	}
+	return tables
+}
+
+// Final returns the tables at the end of the log.
+func (h *SchemaHistory) Final() []*TableSchema {
+	return h.Tables(math.MaxInt)
+}
+
+// Dump returns the statements that create the final schema. Foreign key checks are disabled, since tables are
+// created in the order of their names rather than of their references.
+func (h *SchemaHistory) Dump() string {
+	sb := strings.Builder{}
+	sb.WriteString("SET FOREIGN_KEY_CHECKS = 0;\n")
+	database := ""
+	for i, table := range h.Final() {
+		if i == 0 || table.Database != database {
+			database = table.Database
+			sb.WriteString(fmt.Sprintf("\nCREATE DATABASE IF NOT EXISTS `%s`;\n", database))
+			sb.WriteString(fmt.Sprintf("USE `%s`;\n", database))
+		}
+		sb.WriteString(fmt.Sprintf("\n%s;\n", table.CreateStatement))
+	}
+	return sb.String()
+}
+
+// FailedChanges returns the changes the in-memory catalog couldn't apply.
+func (h *SchemaHistory) FailedChanges() []SchemaChange {
+	failed := []SchemaChange{}
+	for _, change := range h.Changes {
+		if change.Error != "" {
+			failed = append(failed, change)
+		}
+	}
+	return failed
+}
+
+// schemaTableKey identifies a table of the catalog, names are case-insensitive
+func schemaTableKey(database string, name string) string {
+	return strings.ToLower(database + "." + name)
+}
+
+// schemaTracker collects the schema changes of the log while it's read, and replays them at the end of the log.
+// A DOLT_PATCH result of the report is applied on the line of the log query that returned it, results that no query
+// returned are the schema of tables that were created before the log started, so they're applied first.
+type schemaTracker struct {
+	// patches are the DOLT_PATCH results that no query returned yet, by table name
+	patches map[string][]PatchQuery
+	changes []SchemaChange
+	// databaseUses counts the queries per database, patches that no query returned are applied to the most used one
+	databaseUses map[string]int
+	ctx          *sql.Context
+}
+
+func newSchemaTracker(patchQueries []PatchQuery) *schemaTracker {
+	tracker := &schemaTracker{
+		patches:      map[string][]PatchQuery{},
+		changes:      []SchemaChange{},
+		databaseUses: map[string]int{},
+		ctx:          sql.NewEmptyContext(),
+	}
+	for _, patchQuery := range patchQueries {
+		tracker.patches[patchQuery.TableName] = append(tracker.patches[patchQuery.TableName], patchQuery)
+	}
+	return tracker
+}
+
+// process collects the query if it changed the schema, or the DOLT_PATCH result it returned. The database is the
+// one the query ran in, queries that failed didn't change the schema.
+func (t *schemaTracker) process(lineNumber int, query string, node sql.Node, database string, queryError string) {
+	if database != "" {
+		t.databaseUses[database]++
+	}
+	if queryError != "" || node == nil {
+		return
+	}
+
+	if isSchemaChange(node) {
+		t.changes = append(t.changes, SchemaChange{
+			LineNumber: lineNumber,
+			Database:   database,
+			Query:      query,
+			Node:       node,
+		})
+		return
+	}
+	if patchParse := RegexSplit(query, doltPatchQueryRegex); patchParse != nil {
+		patches := t.patches[patchParse[0]]
+		if len(patches) == 0 {
+			return
+		}
+		t.patches[patchParse[0]] = patches[1:]
+		t.changes = append(t.changes, t.patchChanges(patches[0], lineNumber, database)...)
+	}
+}
+
+// patchChanges returns the schema changes among the statements of a DOLT_PATCH result, the others change data.
+func (t *schemaTracker) patchChanges(patchQuery PatchQuery, lineNumber int, database string) []SchemaChange {
+	changes := []SchemaChange{}
+	for _, statement := range patchQuery.Queries {
+		node, err := parse.Parse(t.ctx, statement)
+		if err != nil || !isSchemaChange(node) {
+			continue
+		}
+		changes = append(changes, SchemaChange{
+			LineNumber:       lineNumber,
+			ReportLineNumber: patchQuery.LineNumber,
+			Database:         database,
+			Query:            statement,
+			Node:             node,
+		})
+	}
+	return changes
+}
+
+// end replays the schema changes. The planner, if there is one, analyzes its queries against the schema they saw.
+func (t *schemaTracker) end(planner *queryPlanner) SchemaHistory {
+	database := ""
+	for name, uses := range t.databaseUses {
+		if uses > t.databaseUses[database] || (uses == t.databaseUses[database] && name < database) {
+			database = name
+		}
+	}
+	unreturned := []PatchQuery{}
+	for _, patches := range t.patches {
+		unreturned = append(unreturned, patches...)
+	}
+	sort.Slice(unreturned, func(i, j int) bool {
+		return unreturned[i].LineNumber < unreturned[j].LineNumber
+	})
+
+	changes := []SchemaChange{}
+	for _, patchQuery := range unreturned {
+		changes = append(changes, t.patchChanges(patchQuery, 0, database)...)
+	}
+	return replaySchemaChanges(append(changes, t.changes...), planner)
+}
+
+// isSchemaChange returns whether the statement creates, changes or drops a database, a table or an index.
+func isSchemaChange(node sql.Node) bool {
+	switch node := node.(type) {
+	case *plan.CreateTable, *plan.DropTable, *plan.RenameTable, *plan.TableCopier,
+		*plan.AddColumn, *plan.DropColumn, *plan.RenameColumn, *plan.ModifyColumn,
+		*plan.AlterPK, *plan.AlterIndex, *plan.AlterAutoIncrement, *plan.AlterDefaultSet, *plan.AlterDefaultDrop,
+		*plan.CreateForeignKey, *plan.DropForeignKey, *plan.CreateCheck, *plan.DropCheck, *plan.DropConstraint,
+		*plan.CreateDB, *plan.DropDB:
+		return true
+	case *plan.Block:
+		// ALTER TABLE statements with several changes
+		for _, child := range node.Children() {
+			if isSchemaChange(child) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// schemaChangeTargets returns the keys of the tables that a schema change names. Tables that it creates or drops
+// without naming them, like the tables of a dropped database, are found by listing the catalog.
+func schemaChangeTargets(node sql.Node, database string) []string {
+	targets := []string{}
+	add := func(tableDatabase string, name string) {
+		if tableDatabase == "" {
+			tableDatabase = database
+		}
+		targets = append(targets, schemaTableKey(tableDatabase, name))
+	}
+	transform.Inspect(node, func(node sql.Node) bool {
+		switch node := node.(type) {
+		case *plan.UnresolvedTable:
+			add(node.Database(), node.Name())
+		case *plan.CreateTable:
+			tableDatabase := ""
+			if node.Database() != nil {
+				tableDatabase = node.Database().Name()
+			}
+			add(tableDatabase, node.Name())
+		case *plan.CreateForeignKey:
+			add(node.FkDef.Database, node.FkDef.Table)
+		case *plan.DropForeignKey:
+			add("", node.Table)
+		}
+		return true
+	})
+	return targets
+}
+
+// schemaCatalog is the in-memory catalog that schema changes are replayed into.
+type schemaCatalog struct {
+	provider sql.MutableDatabaseProvider
+	engine   *sqle.Engine
+	ctx      *sql.Context
+}
+
+func newSchemaCatalog() *schemaCatalog {
+	// dolt indexes primary keys, the analyzer only plans lookups on them with native indexes
+	provider := memory.NewDBProviderWithOpts(memory.NativeIndexProvider(true))
+	catalog := &schemaCatalog{
+		provider: provider,
+		engine:   sqle.NewDefault(provider),
+		ctx:      sql.NewEmptyContext(),
+	}
+	// the catalog's tables have no rows, costed by their size every join is cheap, so the plans look up rows
+	// whenever an index allows it, like the server's plans of tables with rows do
+	catalog.engine.Analyzer.Coster = analyzer.NewLookupBiasedCoster()
+	// the foreign keys of created tables may reference tables that the log doesn't create. ALTER TABLE still
+	// checks the referenced table, those changes fail when the log doesn't create it
+	_ = catalog.ctx.SetSessionVariable(catalog.ctx, "foreign_key_checks", int8(0))
+	return catalog
+}
+
+func (c *schemaCatalog) query(query string) ([]sql.Row, error) {
+	_, iter, err := c.engine.Query(c.ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	return sql.RowIterToRows(c.ctx, nil, iter)
+}
+
+// apply runs the schema change in the catalog. The server ran it successfully, so a CREATE TABLE for a table the
+// catalog already has replaces it: the catalog can only have it from a patch or from a part of the log that's missing.
+// The catalog creates the databases that changes run in, a CREATE DATABASE for one of them is already applied.
+// The engine panics on some statements, which is reported like an error.
+func (c *schemaCatalog) apply(change SchemaChange) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("schema change panicked: %v", r)
+		}
+	}()
+	_, createDB := change.Node.(*plan.CreateDB)
+	if change.Database != "" && !createDB && !c.provider.HasDatabase(c.ctx, change.Database) {
+		if err := c.provider.CreateDatabase(c.ctx, change.Database); err != nil {
+			return err
+		}
+	}
+	c.ctx.SetCurrentDatabase(change.Database)
+
+	_, err = c.query(change.Query)
+	if createDB && sql.ErrDatabaseExists.Is(err) {
+		return nil
+	}
+	create, ok := change.Node.(*plan.CreateTable)
+	if !ok || !sql.ErrTableAlreadyExists.Is(err) {
+		return err
+	}
+	name := fmt.Sprintf("`%s`", create.Name())
+	if create.Database() != nil && create.Database().Name() != "" {
+		name = fmt.Sprintf("`%s`.%s", create.Database().Name(), name)
+	}
+	if _, err := c.query("DROP TABLE " + name); err != nil {
+		return err
+	}
+	_, err = c.query(change.Query)
+	return err
+}
+
+// tables returns the database and the name of every table of the catalog, by key.
+func (c *schemaCatalog) tables() map[string][2]string {
+	tables := map[string][2]string{}
+	for _, database := range c.provider.AllDatabases(c.ctx) {
+		names, err := database.GetTableNames(c.ctx)
+		if err != nil {
+			continue
+		}
+		for _, name := range names {
+			tables[schemaTableKey(database.Name(), name)] = [2]string{database.Name(), name}
+		}
+	}
+	return tables
+}
+
+func (c *schemaCatalog) table(database string, name string) (*TableSchema, error) {
+	db, err := c.provider.Database(c.ctx, database)
+	if err != nil {
+		return nil, err
+	}
+	table, ok, err := db.GetTableInsensitive(c.ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, sql.ErrTableNotFound.New(name)
+	}
+	schema := &TableSchema{
+		Database: db.Name(),
+		Name:     table.Name(),
+		Schema:   table.Schema(),
+	}
+	if indexed, ok := table.(sql.IndexAddressable); ok {
+		indexes, err := indexed.GetIndexes(c.ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, index := range indexes {
+			if index.ID() != "PRIMARY" {
+				schema.Indexes = append(schema.Indexes, index)
+			}
+		}
+	}
+	rows, err := c.query(fmt.Sprintf("SHOW CREATE TABLE `%s`.`%s`", schema.Database, schema.Name))
+	if err != nil {
+		return nil, err
+	}
+	if len(rows) == 1 && len(rows[0]) == 2 {
+		schema.CreateStatement, _ = rows[0][1].(string)
+	}
+	return schema, nil
+}
+
+// replaySchemaChanges applies the changes in order to an empty catalog, and keeps a version of every table that
+// a change created, changed or dropped. The planner analyzes the queries of the lines before each change first.
+func replaySchemaChanges(changes []SchemaChange, planner *queryPlanner) SchemaHistory {
+	history := SchemaHistory{
+		Changes:  changes,
+		versions: map[string][]tableVersion{},
+	}
+	catalog := newSchemaCatalog()
+	current := map[string]*TableSchema{}
+	for i := range history.Changes {
+		change := &history.Changes[i]
+		if planner != nil {
+			planner.analyzeUntil(catalog, change.LineNumber)
+		}
+		if err := catalog.apply(*change); err != nil {
+			change.Error = err.Error()
+			continue
+		}
+
+		tables := catalog.tables()
+		for key := range current {
+			if _, ok := tables[key]; !ok {
+				history.versions[key] = append(history.versions[key], tableVersion{lineNumber: change.LineNumber})
+				delete(current, key)
+			}
+		}
+		targets := schemaChangeTargets(change.Node, change.Database)
+		for key, name := range tables {
+			previous, exists := current[key]
+			if exists && !slices.Contains(targets, key) {
+				continue
+			}
+			table, err := catalog.table(name[0], name[1])
+			if err != nil {
+				change.Error = err.Error()
+				continue
+			}
+			if exists && previous.CreateStatement == table.CreateStatement {
+				continue
+			}
+			current[key] = table
+			history.versions[key] = append(history.versions[key], tableVersion{lineNumber: change.LineNumber, table: table})
+		}
+	}
+	if planner != nil {
+		planner.analyzeUntil(catalog, math.MaxInt)
+	}
+	return history
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"fmt"
+	"github.com/dolthub/go-mysql-server/sql"
+	"github.com/dolthub/go-mysql-server/sql/expression"
+	"github.com/dolthub/go-mysql-server/sql/plan"
+	"sort"
+	"strings"
+)
+
+// SessionState is the state of a connection's session, replayed from the SET and USE statements that ran on it.
+// States are never modified once a query points at them, replaying a statement produces a new state.
+type SessionState struct {
+	Database string
+	// Variables holds the session system variables that were set, keyed by lowercase name
+	Variables map[string]string
+	// UserVariables holds the user variables (@name) that were set, keyed by lowercase name
+	UserVariables map[string]string
+}
+
+func NewSessionState(database string) *SessionState {
+	return &SessionState{
+		Database:      database,
+		Variables:     make(map[string]string),
+		UserVariables: make(map[string]string),
+	}
+}
+
+// Autocommit returns the value of @@autocommit, which is on unless the session turned it off.
+func (s *SessionState) Autocommit() bool {
+	value, ok := s.Variables["autocommit"]
+	if !ok {
+		return true
+	}
+	switch strings.ToLower(value) {
+	case "0", "off", "false":
+		return false
+	default:
+		return true
+	}
+}
+
+// SqlMode returns the value of @@sql_mode, or an empty string if the session didn't set it.
+func (s *SessionState) SqlMode() string {
+	return s.Variables["sql_mode"]
+}
+
+func (s *SessionState) String() string {
+	parts := []string{fmt.Sprintf("database=%s", s.Database)}
+	for _, name := range sortedKeys(s.Variables) {
+		parts = append(parts, fmt.Sprintf("%s=%s", name, s.Variables[name]))
+	}
+	for _, name := range sortedKeys(s.UserVariables) {
+		parts = append(parts, fmt.Sprintf("@%s=%s", name, s.UserVariables[name]))
+	}
+	return strings.Join(parts, ", ")
+}
+
+func (s *SessionState) clone() *SessionState {
+	clone := NewSessionState(s.Database)
+	for name, value := range s.Variables {
+		clone.Variables[name] = value
+	}
+	for name, value := range s.UserVariables {
+		clone.UserVariables[name] = value
+	}
+	return clone
+}
+
+// Apply returns the session state after running the given statement, or the same state if the statement
+// doesn't change it. Only session-scoped assignments are replayed, SET GLOBAL doesn't affect the session.
+func (s *SessionState) Apply(node sql.Node) *SessionState {
+	switch node := node.(type) {
+	case *plan.Use:
+		if node.Database() == nil || node.Database().Name() == s.Database {
+			return s
+		}
+		newState := s.clone()
+		newState.Database = node.Database().Name()
+		return newState
+	case *plan.Set:
+		newState := s.clone()
+		changed := false
+		for _, expr := range node.Exprs {
+			setField, ok := expr.(*expression.SetField)
+			if !ok {
+				continue
+			}
+			value, isDefault := sessionValue(setField.Right)
+			var variables map[string]string
+			var name string
+			switch left := setField.Left.(type) {
+			case *expression.UnresolvedColumn:
+				variables, name = newState.Variables, left.Name()
+			case *expression.SystemVar:
+				if left.Scope != sql.SystemVariableScope_Session && left.Scope != sql.SystemVariableScope_Both {
+					continue
+				}
+				variables, name = newState.Variables, left.Name
+			case *expression.UserVar:
+				variables, name = newState.UserVariables, left.Name
+			default:
+				continue
+			}
+			name = strings.ToLower(name)
+			if isDefault {
+				delete(variables, name)
+			} else {
+				variables[name] = value
+			}
+			changed = true
+		}
+		if !changed {
+			return s
+		}
+		return newState
+	default:
+		return s
+	}
+}
+
+// sessionValue returns the text of the value assigned by a SET statement, and whether it resets the variable to its default.
+// Values that aren't literals can't be evaluated without a server, so their expression text is used instead.
+func sessionValue(expr sql.Expression) (string, bool) {
+	switch expr := expr.(type) {
+	case *expression.DefaultColumn:
+		return "", true
+	case *expression.Literal:
+		if expr.Value() == nil {
+			return "NULL", false
+		}
+		return fmt.Sprint(expr.Value()), false
+	default:
+		return expr.String(), false
+	}
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -2,12 +2,14 @@ package main
 
 import (
 	"flag"
+	"fmt"
 	"path/filepath"
+	"strings"
 )
 
 type Settings struct {
-	// Path to the dolt log file
-	doltLogFilePath string
+	// Paths of the dolt log files, read in order as a single log. "-" is stdin.
+	doltLogPaths []string
 	// Path to the pytest report file
 	pytestReportPath string
 	// Path to the output directory
@@ -23,51 +25,259 @@ type Settings struct {
 	logQueryText bool
 	// The extension to use for the output files, taken from the dolt log file name
 	logFileExtension string
+	// Number of entries in the "slowest" sections of the analysis
+	slowestCount int
+	// Number of entries in the suspects report
+	suspectCount int
+	// Lines longer than this many bytes are skipped, so that memory stays bounded
+	maxLineLength int
+	// Number of workers parsing queries in parallel
+	parseWorkers int
+	// Name of the format of the log, or "auto" to detect it from the server banner
+	logFormat string
+	// Presets and files of the markers that tell where tests start and end
+	testMarkers []string
+	// Which test the queries of connections that never sent a marker belong to
+	unmarkedPolicy UnmarkedConnectionPolicy
+	// Paths of JUnit XML test reports, read in addition to the pytest report
+	junitReportPaths []string
+	// Whether to analyze the plan of every query shape against the schema reconstructed from the log
+	resolvePlans bool
 }
 
 func NewSettings(logPath string, pytestReportPath string) Settings {
-	logFileName := filepath.Base(logPath)
-	logFileExt := filepath.Ext(logFileName)
-	outputFileBaseName := logFileName[:len(logFileName)-len(logFileExt)]
+	return NewSettingsForLogs([]string{logPath}, pytestReportPath, "")
+}
+
+// NewSettingsForLogs returns the settings to analyze the given logs as a single log. The output files are named
+// after outputPath, or after the log if there's a single log file and no outputPath.
+func NewSettingsForLogs(logPaths []string, pytestReportPath string, outputPath string) Settings {
+	if outputPath == "" {
+		outputPath = defaultOutputPath(logPaths)
+	}
+	outputFileName := filepath.Base(outputPath)
+	outputFileExt := filepath.Ext(outputFileName)
+	outputFileBaseName := outputFileName[:len(outputFileName)-len(outputFileExt)]
 
 	settings := Settings{
-		doltLogFilePath:    logPath,
+		doltLogPaths:       logPaths,
 		pytestReportPath:   pytestReportPath,
-		outputDirPath:      filepath.Dir(logPath),
+		outputDirPath:      filepath.Dir(outputPath),
 		hideNonTestQueries: false,
 		logQueryText:       true,
 		outputFileBaseName: outputFileBaseName,
-		logFileExtension:   logFileExt,
+		logFileExtension:   outputFileExt,
+		slowestCount:       10,
+		suspectCount:       20,
+		maxLineLength:      defaultMaxLineLength,
+		parseWorkers:       defaultParseWorkers,
+		logFormat:          autoLogFormat,
+		testMarkers:        defaultTestMarkers,
+		unmarkedPolicy:     UnmarkedSingle,
 		logger:             NewConsoleLogger(),
 	}
 	return settings
 }
 
-func readInputs() Settings {
+// defaultOutputPath is the log file itself when there's a single one, without its compression extension.
+// Output for stdin or several logs goes next to the first log file, or in the current directory for stdin.
+func defaultOutputPath(logPaths []string) string {
+	if len(logPaths) == 1 && logPaths[0] != stdinPath {
+		logPath := logPaths[0]
+		for _, ext := range []string{".gz", ".zst"} {
+			logPath = strings.TrimSuffix(logPath, ext)
+		}
+		return logPath
+	}
+	dir := "."
+	if len(logPaths) > 0 && logPaths[0] != stdinPath {
+		dir = filepath.Dir(logPaths[0])
+	}
+	return filepath.Join(dir, "dolt-log.txt")
+}
+
+// stringList is a flag that can be given more than once.
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ", ")
+}
+
+func (l *stringList) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
+func readInputs() (Settings, error) {
 	var verbose bool
-	var logPath string
+	var logPaths stringList
+	var outputPath string
 	var pytestReportPath string
 	var hideNonTestQueries bool
 	var showQueryText bool
+	var slowestCount int
+	var suspectCount int
+	var maxLineLength int
+	var parseWorkers int
+	var logFormat string
+	var testMarkers string
+	var unmarkedConnections string
+	var junitReportPaths stringList
+	var resolvePlans bool
 
-	flag.StringVar(&logPath, "log", "", "Path to the dolt log file")
+	flag.Var(&logPaths, "log", "Path or glob of the dolt log files, gzip and zstd compressed logs are supported. "+
+		"Can be given more than once, logs are read in order as a single log. Use - for stdin.")
+	flag.StringVar(&logFormat, "log-format", autoLogFormat, logFormatUsage())
+	flag.StringVar(&outputPath, "out", "", "Path the output file names are derived from, defaults to the log path")
 	flag.StringVar(&pytestReportPath, "pytest-report", "", "Path to the pytest report file")
+	flag.Var(&junitReportPaths, "junit-report", "Path to a JUnit XML test report, as written by pytest --junitxml or xmlrunner. "+
+		"Can be given more than once.")
+	flag.StringVar(&testMarkers, "test-markers", strings.Join(defaultTestMarkers, ","), testMarkersUsage())
+	flag.StringVar(&unmarkedConnections, "unmarked-connections", string(UnmarkedSingle),
+		"Test of the queries of connections that never sent a test marker: "+
+			"none leaves them unattributed, single attributes them to the running test if only one is running, "+
+			"latest attributes them to the test that started last")
 	flag.BoolVar(&hideNonTestQueries, "hide-non-test-queries", false, "Whether to hide queries that are not associated with a test")
 	flag.BoolVar(&showQueryText, "show-query-text", false, "Whether to log query text")
+	flag.IntVar(&slowestCount, "slowest", 10, "Number of query shapes, queries and tests to list in the slowest sections of the analysis")
+	flag.IntVar(&suspectCount, "suspects", 20, "Number of query shapes, tables and SQL constructs to list in the suspects report, "+
+		"ranked by how much more often failing tests run them than passing tests")
+	flag.BoolVar(&resolvePlans, "plans", false, "Whether to run every query shape through the go-mysql-server analyzer "+
+		"against the schema reconstructed from the log, and list the plans with their full table scans and joins")
+	flag.IntVar(&parseWorkers, "workers", defaultParseWorkers, "Number of workers parsing queries in parallel")
+	flag.IntVar(&maxLineLength, "max-line-length", defaultMaxLineLength, "Lines of the log and the pytest report longer than this many bytes are skipped")
 
 	flag.BoolVar(&verbose, "verbose", false, "Whether to log to stdout")
 	flag.BoolVar(&verbose, "v", false, "Whether to log to stdout")
 	flag.Parse()
+	if slowestCount < 0 {
+		return Settings{}, fmt.Errorf("-slowest must not be negative, got %d", slowestCount)
+	}
+	if suspectCount < 0 {
+		return Settings{}, fmt.Errorf("-suspects must not be negative, got %d", suspectCount)
+	}
+	if err := checkMaxLineLength(maxLineLength); err != nil {
+		return Settings{}, err
+	}
+
+	// logs can also be given as arguments, after the flags
+	logPaths = append(logPaths, flag.Args()...)
+	expandedLogPaths, err := expandLogPaths(logPaths)
+	if err != nil {
+		return Settings{}, err
+	}
 
-	settings := NewSettings(logPath, pytestReportPath)
+	settings := NewSettingsForLogs(expandedLogPaths, pytestReportPath, outputPath)
 	settings.hideNonTestQueries = hideNonTestQueries
 	settings.logQueryText = showQueryText
+	settings.slowestCount = slowestCount
+	settings.suspectCount = suspectCount
+	settings.maxLineLength = maxLineLength
+	settings.parseWorkers = parseWorkers
+	settings.logFormat = logFormat
+	if _, err := logFormatForName(logFormat); err != nil {
+		return Settings{}, err
+	}
+	settings.testMarkers = strings.Split(testMarkers, ",")
+	if _, err := loadTestMarkers(settings.testMarkers); err != nil {
+		return Settings{}, err
+	}
+	settings.unmarkedPolicy, err = parseUnmarkedConnectionPolicy(unmarkedConnections)
+	if err != nil {
+		return Settings{}, err
+	}
+	settings.junitReportPaths = junitReportPaths
+	settings.resolvePlans = resolvePlans
 	if !verbose {
 		settings.logger = NewNoopLogger()
 	}
-	return settings
+	return settings, nil
+}
+
+// checkMaxLineLength rejects maximum line lengths that would skip every line.
+func checkMaxLineLength(maxLineLength int) error {
+	if maxLineLength <= 0 {
+		return fmt.Errorf("-max-line-length must be positive, got %d", maxLineLength)
+	}
+	return nil
 }
 
 func (s *Settings) GetOutputFilePath(suffix string) string {
 	return filepath.Join(s.outputDirPath, s.outputFileBaseName+suffix+s.logFileExtension)
 }
+
+// readDiffInputs reads the flags of the diff mode, which compares a run with a base run of the same suite. The
+// flags of the log format and of the test markers apply to both runs.
+func readDiffInputs(args []string) (base Settings, compared Settings, err error) {
+	flags := flag.NewFlagSet("diff", flag.ExitOnError)
+	var verbose bool
+	var baseLogPaths, logPaths stringList
+	var baseJUnitReportPaths, junitReportPaths stringList
+	var basePytestReportPath, pytestReportPath string
+	var outputPath string
+	var logFormat string
+	var testMarkers string
+	var unmarkedConnections string
+	var maxLineLength int
+	var parseWorkers int
+
+	flags.Var(&baseLogPaths, "base-log", "Path or glob of the dolt log files of the base run, e.g. a run against MySQL or "+
+		"another dolt release. Can be given more than once.")
+	flags.StringVar(&basePytestReportPath, "base-pytest-report", "", "Path to the pytest report file of the base run")
+	flags.Var(&baseJUnitReportPaths, "base-junit-report", "Path to a JUnit XML test report of the base run")
+	flags.Var(&logPaths, "log", "Path or glob of the dolt log files of the compared run. Can be given more than once.")
+	flags.StringVar(&pytestReportPath, "pytest-report", "", "Path to the pytest report file of the compared run")
+	flags.Var(&junitReportPaths, "junit-report", "Path to a JUnit XML test report of the compared run")
+	flags.StringVar(&outputPath, "out", "", "Path the diff output file name is derived from, defaults to the compared log path")
+	flags.StringVar(&logFormat, "log-format", autoLogFormat, logFormatUsage())
+	flags.StringVar(&testMarkers, "test-markers", strings.Join(defaultTestMarkers, ","), testMarkersUsage())
+	flags.StringVar(&unmarkedConnections, "unmarked-connections", string(UnmarkedSingle),
+		"Test of the queries of connections that never sent a test marker: none, single or latest")
+	flags.IntVar(&parseWorkers, "workers", defaultParseWorkers, "Number of workers parsing queries in parallel")
+	flags.IntVar(&maxLineLength, "max-line-length", defaultMaxLineLength, "Lines of the logs and the reports longer than this many bytes are skipped")
+	flags.BoolVar(&verbose, "verbose", false, "Whether to log to stdout")
+	flags.BoolVar(&verbose, "v", false, "Whether to log to stdout")
+	if err := flags.Parse(args); err != nil {
+		return base, compared, err
+	}
+	if err := checkMaxLineLength(maxLineLength); err != nil {
+		return base, compared, err
+	}
+
+	unmarkedPolicy, err := parseUnmarkedConnectionPolicy(unmarkedConnections)
+	if err != nil {
+		return base, compared, err
+	}
+	if _, err := logFormatForName(logFormat); err != nil {
+		return base, compared, err
+	}
+	if _, err := loadTestMarkers(strings.Split(testMarkers, ",")); err != nil {
+		return base, compared, err
+	}
+	runSettings := func(logPaths []string, pytestReportPath string, junitReportPaths []string, outputPath string) (Settings, error) {
+		if len(logPaths) == 0 {
+			return Settings{}, fmt.Errorf("diff needs the logs of both runs, given with -base-log and -log")
+		}
+		expandedLogPaths, err := expandLogPaths(logPaths)
+		if err != nil {
+			return Settings{}, err
+		}
+		settings := NewSettingsForLogs(expandedLogPaths, pytestReportPath, outputPath)
+		settings.junitReportPaths = junitReportPaths
+		settings.logFormat = logFormat
+		settings.testMarkers = strings.Split(testMarkers, ",")
+		settings.unmarkedPolicy = unmarkedPolicy
+		settings.maxLineLength = maxLineLength
+		settings.parseWorkers = parseWorkers
+		if !verbose {
+			settings.logger = NewNoopLogger()
+		}
+		return settings, nil
+	}
+	base, err = runSettings(baseLogPaths, basePytestReportPath, baseJUnitReportPaths, "")
+	if err != nil {
+		return base, compared, err
+	}
+	compared, err = runSettings(logPaths, pytestReportPath, junitReportPaths, outputPath)
+	return base, compared, err
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+// DurationStats summarizes the durations of a group of queries. Queries that errored aren't included,
+// dolt doesn't log how long those took.
+type DurationStats struct {
+	Count int
+	Total time.Duration
+	Min   time.Duration
+	Mean  time.Duration
+	P50   time.Duration
+	P95   time.Duration
+	P99   time.Duration
+	Max   time.Duration
+}
+
+func NewDurationStats(queries []Query) DurationStats {
+	durations := make([]time.Duration, 0, len(queries))
+	for _, query := range queries {
+		if query.Error == "" {
+			durations = append(durations, query.Duration)
+		}
+	}
+
+	stats := DurationStats{Count: len(durations)}
+	if len(durations) == 0 {
+		return stats
+	}
+
+	sort.Slice(durations, func(i, j int) bool {
+		return durations[i] < durations[j]
+	})
+	for _, duration := range durations {
+		stats.Total += duration
+	}
+	stats.Min = durations[0]
+	stats.Max = durations[len(durations)-1]
+	stats.Mean = stats.Total / time.Duration(len(durations))
+	stats.P50 = percentile(durations, 50)
+	stats.P95 = percentile(durations, 95)
+	stats.P99 = percentile(durations, 99)
+	return stats
+}
+
+func (s DurationStats) String() string {
+	return fmt.Sprintf("count: %d, total: %s, min: %s, mean: %s, p50: %s, p95: %s, p99: %s, max: %s",
+		s.Count, s.Total, s.Min, s.Mean, s.P50, s.P95, s.P99, s.Max)
+}
+
+// percentile returns the nearest-rank percentile of the sorted durations.
+func percentile(sorted []time.Duration, p int) time.Duration {
+	rank := (p*len(sorted) + 99) / 100
+	if rank < 1 {
+		rank = 1
+	}
+	return sorted[rank-1]
+}
+
+// slowestShapes returns the query groups with the highest total duration, slowest first.
+func slowestShapes(groups map[string][]Query, count int) []Pair[string, DurationStats] {
+	shapes := make([]Pair[string, DurationStats], 0, len(groups))
+	for dbg, queries := range groups {
+		shapes = append(shapes, Pair[string, DurationStats]{dbg, NewDurationStats(queries)})
+	}
+	sort.SliceStable(shapes, func(i, j int) bool {
+		left, right := shapes[i], shapes[j]
+		if left.Second.Total != right.Second.Total {
+			return left.Second.Total > right.Second.Total
+		}
+		return left.First < right.First
+	})
+	if len(shapes) > count {
+		shapes = shapes[:count]
+	}
+	return shapes
+}
+
+// slowestQueries returns the individual queries that took the longest, slowest first.
+func slowestQueries(queries []Query, count int) []Query {
+	sorted := make([]Query, 0, len(queries))
+	for _, query := range queries {
+		if query.Error == "" {
+			sorted = append(sorted, query)
+		}
+	}
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Duration > sorted[j].Duration
+	})
+	if len(sorted) > count {
+		sorted = sorted[:count]
+	}
+	return sorted
+}
+
+// slowestTests returns the tests whose queries took the longest in total, slowest first.
+func slowestTests(tests []Test, count int) []Pair[string, DurationStats] {
+	result := make([]Pair[string, DurationStats], 0, len(tests))
+	for _, test := range tests {
+		result = append(result, Pair[string, DurationStats]{test.Id, NewDurationStats(test.Queries)})
+	}
+	sort.SliceStable(result, func(i, j int) bool {
+		return result[i].Second.Total > result[j].Second.Total
+	})
+	if len(result) > count {
+		result = result[:count]
+	}
+	return result
+}
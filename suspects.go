@@ -0,0 +1,197 @@
+package main
+
+import (
+	"fmt"
+	"github.com/dolthub/go-mysql-server/sql"
+	"github.com/dolthub/go-mysql-server/sql/expression"
+	"github.com/dolthub/go-mysql-server/sql/plan"
+	"github.com/dolthub/go-mysql-server/sql/transform"
+	"golang.org/x/exp/slices"
+	"math"
+	"sort"
+	"strings"
+)
+
+// SuspectKind is the kind of feature of a test's queries that a suspect is.
+type SuspectKind string
+
+const (
+	SuspectShape     SuspectKind = "shape"
+	SuspectTable     SuspectKind = "table"
+	SuspectConstruct SuspectKind = "construct"
+)
+
+// suspectKinds are in the order suspects of the same score are listed, the most specific first
+var suspectKinds = []SuspectKind{SuspectShape, SuspectConstruct, SuspectTable}
+
+// Suspect is a query shape, table or SQL construct that the queries of failing tests have more often than the
+// queries of passing tests. It's scored like a statement in spectrum-based fault localization, with the tests as
+// the runs: a suspect that all failing tests and no passing tests have scores 1.
+type Suspect struct {
+	Kind SuspectKind
+	// Key is the fingerprint id of shapes, the table name of tables, and the node or function name of constructs
+	Key string
+	// Text is the fingerprint text of shapes
+	Text      string
+	Ochiai    float64
+	Tarantula float64
+	// FailingTests are the ids of the failing tests whose queries have the suspect
+	FailingTests []string
+	// PassingTests is the number of passing tests whose queries have the suspect
+	PassingTests int
+}
+
+func (s *Suspect) String() string {
+	sb := strings.Builder{}
+	sb.WriteString(fmt.Sprintf("%s %s\n", s.Kind, s.Key))
+	if s.Text != "" {
+		sb.WriteString(fmt.Sprintf("%s\n", s.Text))
+	}
+	sb.WriteString(fmt.Sprintf("Ochiai: %.3f, Tarantula: %.3f\n", s.Ochiai, s.Tarantula))
+	sb.WriteString(fmt.Sprintf("Failing tests: %d, passing tests: %d\n", len(s.FailingTests), s.PassingTests))
+	for _, testId := range s.FailingTests {
+		sb.WriteString(fmt.Sprintf("  %s\n", testId))
+	}
+	return sb.String()
+}
+
+// suspectKey identifies a suspect across tests
+type suspectKey struct {
+	Kind SuspectKind
+	Key  string
+}
+
+// rankSuspects returns the suspects that the queries of at least one failing test have, most suspicious first.
+// Ubiquitous shapes like SET autocommit = 0 rank low, since passing tests have them as often as failing tests.
+// Queries of test classes outside of their tests aren't part of a test, so they aren't counted.
+func rankSuspects(tests []Test) []Suspect {
+	failing, passing := 0, 0
+	suspects := map[suspectKey]*Suspect{}
+	for _, test := range tests {
+		if test.Id == test.ClassId {
+			continue
+		}
+		if test.Failed {
+			failing++
+		} else {
+			passing++
+		}
+		for key, text := range testSuspectKeys(test) {
+			suspect, ok := suspects[key]
+			if !ok {
+				suspect = &Suspect{Kind: key.Kind, Key: key.Key, Text: text}
+				suspects[key] = suspect
+			}
+			if test.Failed {
+				suspect.FailingTests = append(suspect.FailingTests, test.Id)
+			} else {
+				suspect.PassingTests++
+			}
+		}
+	}
+
+	ranked := make([]Suspect, 0, len(suspects))
+	for _, suspect := range suspects {
+		if len(suspect.FailingTests) == 0 {
+			continue
+		}
+		suspect.Ochiai, suspect.Tarantula = suspiciousness(len(suspect.FailingTests), suspect.PassingTests, failing, passing)
+		ranked = append(ranked, *suspect)
+	}
+	sort.SliceStable(ranked, func(i, j int) bool {
+		left, right := ranked[i], ranked[j]
+		switch {
+		case left.Ochiai != right.Ochiai:
+			return left.Ochiai > right.Ochiai
+		case left.Tarantula != right.Tarantula:
+			return left.Tarantula > right.Tarantula
+		case len(left.FailingTests) != len(right.FailingTests):
+			return len(left.FailingTests) > len(right.FailingTests)
+		case left.Kind != right.Kind:
+			return slices.Index(suspectKinds, left.Kind) < slices.Index(suspectKinds, right.Kind)
+		default:
+			return left.Key < right.Key
+		}
+	})
+	return ranked
+}
+
+// suspiciousness returns the Ochiai and Tarantula scores of a suspect that failedWith failing tests and
+// passedWith passing tests have, out of failed failing and passed passing tests.
+func suspiciousness(failedWith int, passedWith int, failed int, passed int) (ochiai float64, tarantula float64) {
+	if failedWith == 0 {
+		return 0, 0
+	}
+	ochiai = float64(failedWith) / math.Sqrt(float64(failed*(failedWith+passedWith)))
+	failedRatio := float64(failedWith) / float64(failed)
+	passedRatio := 0.0
+	if passed > 0 {
+		passedRatio = float64(passedWith) / float64(passed)
+	}
+	tarantula = failedRatio / (failedRatio + passedRatio)
+	return ochiai, tarantula
+}
+
+// testSuspectKeys returns the shapes, tables and constructs of the test's queries, with the text of the shapes.
+func testSuspectKeys(test Test) map[suspectKey]string {
+	keys := map[suspectKey]string{}
+	for _, table := range test.TablesUsed {
+		keys[suspectKey{SuspectTable, table}] = ""
+	}
+	for _, query := range test.Queries {
+		keys[suspectKey{SuspectShape, query.Fingerprint.Id}] = query.Fingerprint.Text
+		for _, construct := range queryConstructs(query.Node) {
+			keys[suspectKey{SuspectConstruct, construct}] = ""
+		}
+	}
+	return keys
+}
+
+// queryConstructs returns the names of the nodes and expressions of the query's tree, and of the functions it calls,
+// e.g. plan.InnerJoin, expression.Like and function JSON_EXTRACT. Table and column references and literals are left
+// out, tables are suspects of their own.
+func queryConstructs(node sql.Node) []string {
+	constructs := []string{}
+	add := func(construct string) {
+		for _, existing := range constructs {
+			if existing == construct {
+				return
+			}
+		}
+		constructs = append(constructs, construct)
+	}
+	if node == nil {
+		return constructs
+	}
+	transform.Inspect(node, func(node sql.Node) bool {
+		switch node.(type) {
+		case nil, *plan.UnresolvedTable, *plan.ResolvedTable:
+			return true
+		}
+		add(strings.TrimPrefix(fmt.Sprintf("%T", node), "*"))
+
+		expressioner, ok := node.(sql.Expressioner)
+		if !ok {
+			return true
+		}
+		for _, expr := range expressioner.Expressions() {
+			transform.InspectExpr(expr, func(expr sql.Expression) bool {
+				switch expr := expr.(type) {
+				case nil, *expression.UnresolvedColumn, *expression.GetField, *expression.Literal, *expression.Alias:
+				case *expression.UnresolvedFunction:
+					add("function " + strings.ToUpper(expr.Name()))
+				case *plan.Subquery:
+					add("plan.Subquery")
+					for _, construct := range queryConstructs(expr.Query) {
+						add(construct)
+					}
+				default:
+					add(strings.TrimPrefix(fmt.Sprintf("%T", expr), "*"))
+				}
+				return false
+			})
+		}
+		return true
+	})
+	return constructs
+}
@@ -0,0 +1,385 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// TestStatus is the outcome of a test, as the Django test runner reports it.
+type TestStatus string
+
+const (
+	TestPassed            TestStatus = "ok"
+	TestFailed            TestStatus = "FAIL"
+	TestErrored           TestStatus = "ERROR"
+	TestSkipped           TestStatus = "skipped"
+	TestExpectedFailure   TestStatus = "expected failure"
+	TestUnexpectedSuccess TestStatus = "unexpected success"
+)
+
+var testStatuses = []TestStatus{TestPassed, TestFailed, TestErrored, TestSkipped, TestExpectedFailure, TestUnexpectedSuccess}
+
+// Failed returns whether the status fails the test run.
+func (s TestStatus) Failed() bool {
+	return s == TestFailed || s == TestErrored || s == TestUnexpectedSuccess
+}
+
+// TestResult is a test that the test runner reported, with --verbosity 2 or more.
+type TestResult struct {
+	// Id is the id that markers use for the test, e.g. nautobot.dcim.tests.test_filters.PlatformTestCase.test_name
+	Id     string
+	Status TestStatus
+	// Reason is the reason given for skipping the test
+	Reason string
+	// Docstring is the first line of the test's docstring, which the runner prints on the line of the result
+	Docstring string
+	// Duration is only set by runners that time tests, e.g. with --durations
+	Duration time.Duration
+	// Order is the position of the test in the run, starting at 1
+	Order      int
+	LineNumber int
+}
+
+func (r *TestResult) String() string {
+	sb := strings.Builder{}
+	sb.WriteString(fmt.Sprintf("%s ... %s", r.Id, r.Status))
+	if r.Reason != "" {
+		sb.WriteString(fmt.Sprintf(" '%s'", r.Reason))
+	}
+	if r.Duration != 0 {
+		sb.WriteString(fmt.Sprintf(" (%s)", r.Duration))
+	}
+	if r.Docstring != "" {
+		sb.WriteString(fmt.Sprintf("\n%s", r.Docstring))
+	}
+	return sb.String()
+}
+
+// testResultParser collects the test results from the lines of a test runner report:
+//
+//	test_description (nautobot.dcim.tests.test_filters.PlatformTestCase) ... ok
+//	test_id (nautobot.dcim.tests.test_filters.PlatformTestCase)
+//	Verify that the filterset supports filtering by id. ... ok
+type testResultParser struct {
+	results []TestResult
+	// pending is the test whose result isn't on its line, because the runner printed its docstring first
+	// or the test printed output of its own
+	pending *TestResult
+	// inDurations is set within the "Slowest test durations" section
+	inDurations bool
+}
+
+func newTestResultParser() *testResultParser {
+	return &testResultParser{results: []TestResult{}}
+}
+
+func (p *testResultParser) line(lineNumber int, line string) {
+	if p.inDurations {
+		if durationParse := RegexSplit(line, testDurationRegex); durationParse != nil {
+			p.setDuration(durationParse[1], durationParse[0])
+			return
+		}
+		if !strings.HasPrefix(line, "---") {
+			p.inDurations = false
+		}
+	}
+	if line == "Slowest test durations" {
+		p.inDurations = true
+		return
+	}
+
+	if resultParse := RegexSplit(line, testResultRegex); resultParse != nil {
+		p.flushPending()
+		result := TestResult{
+			Id:         testResultId(resultParse[0], resultParse[1]),
+			Order:      len(p.results) + 1,
+			LineNumber: lineNumber,
+		}
+		if !result.setStatus(resultParse[2]) {
+			p.pending = &result
+			return
+		}
+		p.results = append(p.results, result)
+		return
+	}
+	if p.pending == nil {
+		return
+	}
+	if p.pending.setStatus(line) {
+		p.flushPending()
+		return
+	}
+	// the runner prints the first line of the docstring after the name, and the result after the docstring
+	if docstring, status, found := strings.Cut(line, " ... "); found && p.pending.Docstring == "" {
+		p.pending.Docstring = docstring
+		if p.pending.setStatus(status) {
+			p.flushPending()
+		}
+	}
+}
+
+// end returns the results in the order the tests ran. Tests without a result, e.g. because the run was
+// interrupted, aren't reported.
+func (p *testResultParser) end() []TestResult {
+	p.pending = nil
+	return p.results
+}
+
+func (p *testResultParser) flushPending() {
+	if p.pending != nil && p.pending.Status != "" {
+		p.results = append(p.results, *p.pending)
+	}
+	p.pending = nil
+}
+
+func (p *testResultParser) setDuration(pyTestName string, seconds string) {
+	nameParse := RegexSplit(pyTestName, pyTestNameRegex)
+	if nameParse == nil {
+		return
+	}
+	id := testResultId(nameParse[0], nameParse[1])
+	duration, err := strconv.ParseFloat(seconds, 64)
+	if err != nil {
+		return
+	}
+	for i := range p.results {
+		if p.results[i].Id == id {
+			p.results[i].Duration = time.Duration(duration * float64(time.Second))
+		}
+	}
+}
+
+// setStatus sets the status from the text after "...", and returns false if the text isn't a status.
+func (r *TestResult) setStatus(text string) bool {
+	text = strings.TrimSpace(text)
+	if durationParse := RegexSplit(text, testResultDurationRegex); durationParse != nil {
+		seconds, err := strconv.ParseFloat(durationParse[1], 64)
+		if err == nil {
+			r.Duration = time.Duration(seconds * float64(time.Second))
+		}
+		text = durationParse[0]
+	}
+	for _, status := range testStatuses {
+		if text == string(status) {
+			r.Status = status
+			return true
+		}
+	}
+	if strings.HasPrefix(text, string(TestSkipped)+" ") {
+		r.Status = TestSkipped
+		r.Reason = strings.Trim(strings.TrimPrefix(text, string(TestSkipped)+" "), `'"`)
+		return true
+	}
+	return false
+}
+
+// testResultId returns the id of a test from the name and the class that the runner prints. Python 3.11 and later
+// print the full id instead of the class.
+func testResultId(name string, class string) string {
+	if strings.HasSuffix(class, "."+name) {
+		return class
+	}
+	return class + "." + name
+}
+
+// compareTestResults returns the reported tests that ran without sending a marker query, and the tests that
+// markers tell about but that aren't in the report. Skipped tests don't run, so they don't need markers.
+func compareTestResults(results []TestResult, tests []Test) (unmarked []TestResult, unreported []Test) {
+	marked := make(map[string]bool)
+	for _, test := range tests {
+		marked[test.Id] = true
+	}
+	reported := make(map[string]bool)
+	for _, result := range results {
+		reported[result.Id] = true
+		if result.Status != TestSkipped && !marked[result.Id] {
+			unmarked = append(unmarked, result)
+		}
+	}
+	for _, test := range tests {
+		// queries of a class outside of its tests are attributed to the class, which isn't a test
+		if test.Id == test.ClassId {
+			continue
+		}
+		if !reported[test.Id] {
+			unreported = append(unreported, test)
+		}
+	}
+	return unmarked, unreported
+}
+
+// countTestStatuses summarizes the results, e.g. "ok 11, FAIL 1".
+func countTestStatuses(results []TestResult) string {
+	counts := []string{}
+	for _, status := range testStatuses {
+		count := Count(results, func(result TestResult) bool {
+			return result.Status == status
+		})
+		if count > 0 {
+			counts = append(counts, fmt.Sprintf("%s %d", status, count))
+		}
+	}
+	return strings.Join(counts, ", ")
+}
+
+// TracebackFrame is a frame of a Python traceback.
+type TracebackFrame struct {
+	File string
+	Line int
+	// Function is empty for the frames of pytest tracebacks that raised the exception
+	Function string
+	// Code is the source line of the frame, empty if Python couldn't read the source
+	Code string
+}
+
+// TestFailure is the traceback the test runner reported for a failed or errored test.
+type TestFailure struct {
+	Id     string
+	Status TestStatus
+	// Frames are the frames of the last traceback, the one of the exception that failed the test
+	Frames []TracebackFrame
+	// Exception is the exception and its message, e.g. "AssertionError: 0 != 2", the message can span lines
+	Exception  string
+	LineNumber int
+}
+
+// Summary returns the first line of the exception and where it was raised.
+func (f *TestFailure) Summary() string {
+	exception, _, _ := strings.Cut(f.Exception, "\n")
+	if len(f.Frames) == 0 {
+		return exception
+	}
+	frame := f.Frames[len(f.Frames)-1]
+	if frame.Function == "" {
+		return fmt.Sprintf("%s (%s:%d)", exception, filepath.Base(frame.File), frame.Line)
+	}
+	return fmt.Sprintf("%s (%s:%d, in %s)", exception, filepath.Base(frame.File), frame.Line, frame.Function)
+}
+
+func (f *TestFailure) String() string {
+	sb := strings.Builder{}
+	sb.WriteString(fmt.Sprintf("%s: report line %d\n", f.Status, f.LineNumber))
+	if len(f.Frames) > 0 {
+		sb.WriteString("Traceback (most recent call last):\n")
+	}
+	for _, frame := range f.Frames {
+		sb.WriteString(fmt.Sprintf("  File \"%s\", line %d", frame.File, frame.Line))
+		if frame.Function != "" {
+			sb.WriteString(fmt.Sprintf(", in %s", frame.Function))
+		}
+		sb.WriteString("\n")
+		if frame.Code != "" {
+			sb.WriteString(fmt.Sprintf("    %s\n", frame.Code))
+		}
+	}
+	sb.WriteString(f.Exception)
+	return sb.String()
+}
+
+// testFailureParser collects the tracebacks from the failures section of a test runner report, which follows the
+// results:
+//
+//	======================================================================
+//	FAIL: test_napalm_args (nautobot.dcim.tests.test_filters.PlatformTestCase)
+//	----------------------------------------------------------------------
+//	Traceback (most recent call last):
+//	  File "/source/nautobot/dcim/tests/test_filters.py", line 2416, in test_napalm_args
+//	    self.assertEqual(self.filterset(params, self.queryset).qs.count(), len(napalm_args))
+//	AssertionError: 0 != 2
+type testFailureParser struct {
+	failures []TestFailure
+	current  *TestFailure
+	// exception holds the lines of the exception of the current failure, once its frames are read
+	exception     []string
+	lastLine      string
+	frameCodeRead bool
+}
+
+func newTestFailureParser() *testFailureParser {
+	return &testFailureParser{failures: []TestFailure{}}
+}
+
+func (p *testFailureParser) line(lineNumber int, line string) {
+	previous := p.lastLine
+	p.lastLine = line
+	if line == pytestReportSeparator {
+		p.flush()
+		return
+	}
+	if previous == pytestReportSeparator {
+		for _, header := range []Pair[TestStatus, string]{{TestFailed, pyTestFailedRegex}, {TestErrored, pyTestErrorRegex}} {
+			if headerParse := RegexSplit(line, "^"+header.Second+"$"); headerParse != nil {
+				p.current = &TestFailure{
+					Id:         testResultId(headerParse[0], headerParse[1]),
+					Status:     header.First,
+					LineNumber: lineNumber,
+				}
+				return
+			}
+		}
+	}
+	if p.current == nil {
+		return
+	}
+	// the dashes under the header, and the ones that end the last failure before the summary
+	if strings.HasPrefix(line, "------") {
+		if p.exception != nil || len(p.current.Frames) > 0 {
+			p.flush()
+		}
+		return
+	}
+	// a chained exception starts another traceback, the last one is the exception that failed the test
+	if line == "Traceback (most recent call last):" {
+		p.current.Frames = nil
+		p.exception = nil
+		return
+	}
+	if p.exception == nil {
+		if frameParse := RegexSplit(line, tracebackFrameRegex); frameParse != nil {
+			lineNumber, _ := strconv.Atoi(frameParse[1])
+			p.current.Frames = append(p.current.Frames, TracebackFrame{File: frameParse[0], Line: lineNumber, Function: frameParse[2]})
+			p.frameCodeRead = false
+			return
+		}
+		// the source line of the frame, and the carets under it in Python 3.11 and later
+		if strings.HasPrefix(line, "  ") {
+			if frames := p.current.Frames; len(frames) > 0 && !p.frameCodeRead {
+				frames[len(frames)-1].Code = strings.TrimSpace(line)
+				p.frameCodeRead = true
+			}
+			return
+		}
+		if line == "" {
+			return
+		}
+	}
+	p.exception = append(p.exception, line)
+}
+
+func (p *testFailureParser) flush() {
+	if p.current != nil {
+		p.current.Exception = strings.TrimSpace(strings.Join(p.exception, "\n"))
+		p.failures = append(p.failures, *p.current)
+	}
+	p.current = nil
+	p.exception = nil
+}
+
+// parseTraceback sets the frames and the exception of the failure from the text of a Python traceback.
+func parseTraceback(failure *TestFailure, traceback string) {
+	p := newTestFailureParser()
+	p.current = failure
+	for _, line := range strings.Split(strings.Trim(traceback, "\r\n"), "\n") {
+		p.line(failure.LineNumber, strings.TrimSuffix(line, "\r"))
+	}
+	p.flush()
+}
+
+// end returns the failures in the order of the report.
+func (p *testFailureParser) end() []TestFailure {
+	p.flush()
+	return p.failures
+}
@@ -0,0 +1,459 @@
+package main
+
+import (
+	"fmt"
+	"github.com/dolthub/go-mysql-server/sql"
+	"github.com/dolthub/go-mysql-server/sql/plan"
+	"sort"
+	"strings"
+)
+
+type TransactionOutcome int
+
+const (
+	TransactionOpen TransactionOutcome = iota
+	TransactionCommitted
+	TransactionRolledBack
+	// TransactionImplicitlyCommitted is a transaction ended by a statement that commits implicitly,
+	// like DDL, BEGIN or turning autocommit back on
+	TransactionImplicitlyCommitted
+	// TransactionAbandoned is a transaction that was still open when its connection closed
+	TransactionAbandoned
+)
+
+func (o TransactionOutcome) String() string {
+	switch o {
+	case TransactionOpen:
+		return "open at end of log"
+	case TransactionCommitted:
+		return "committed"
+	case TransactionRolledBack:
+		return "rolled back"
+	case TransactionImplicitlyCommitted:
+		return "implicitly committed"
+	case TransactionAbandoned:
+		return "open when connection closed"
+	default:
+		return fmt.Sprintf("unknown outcome %d", int(o))
+	}
+}
+
+type Savepoint struct {
+	Name              string
+	CreatedLineNumber int
+	// RollbackLineNumbers lists every ROLLBACK TO SAVEPOINT that targeted this savepoint
+	RollbackLineNumbers []int
+	// ReleasedLineNumber is zero if the savepoint wasn't released, either explicitly or by rolling back to an outer one
+	ReleasedLineNumber int
+	// Children are the savepoints created while this savepoint was the innermost one
+	Children []*Savepoint
+}
+
+// TransactionStatement is a statement that ran in a transaction, Depth is the number of savepoints active at the time.
+type TransactionStatement struct {
+	Query Query
+	Depth int
+}
+
+type Transaction struct {
+	Id           int
+	ConnectionId int
+	// Explicit is true for transactions started with BEGIN or START TRANSACTION
+	// rather than by the first statement that ran with autocommit off
+	Explicit        bool
+	StartLineNumber int
+	// EndLineNumber is the line of the statement or ConnectionClosed record that ended the transaction
+	EndLineNumber int
+	Outcome       TransactionOutcome
+	Statements    []TransactionStatement
+	Savepoints    []*Savepoint
+}
+
+func (t *Transaction) String(lines *LogLines) string {
+	sb := strings.Builder{}
+	sb.WriteString(fmt.Sprintf("Transaction %d, connection %d\n", t.Id, t.ConnectionId))
+	if t.Explicit {
+		sb.WriteString(fmt.Sprintf("Started: line %s, explicitly\n", lines.Location(t.StartLineNumber)))
+	} else {
+		sb.WriteString(fmt.Sprintf("Started: line %s, autocommit off\n", lines.Location(t.StartLineNumber)))
+	}
+	if t.EndLineNumber != 0 {
+		sb.WriteString(fmt.Sprintf("Outcome: %s on line %s\n", t.Outcome, lines.Location(t.EndLineNumber)))
+	} else {
+		sb.WriteString(fmt.Sprintf("Outcome: %s\n", t.Outcome))
+	}
+
+	if len(t.Savepoints) > 0 {
+		sb.WriteString("Savepoints:\n")
+		var writeSavepoints func(savepoints []*Savepoint, depth int)
+		writeSavepoints = func(savepoints []*Savepoint, depth int) {
+			for _, savepoint := range savepoints {
+				sb.WriteString(fmt.Sprintf("%s%s, created on line %s", strings.Repeat("  ", depth+1), savepoint.Name, lines.Location(savepoint.CreatedLineNumber)))
+				for _, lineNumber := range savepoint.RollbackLineNumbers {
+					sb.WriteString(fmt.Sprintf(", rolled back to on line %s", lines.Location(lineNumber)))
+				}
+				if savepoint.ReleasedLineNumber != 0 {
+					sb.WriteString(fmt.Sprintf(", released on line %s", lines.Location(savepoint.ReleasedLineNumber)))
+				}
+				sb.WriteString("\n")
+				writeSavepoints(savepoint.Children, depth+1)
+			}
+		}
+		writeSavepoints(t.Savepoints, 0)
+	}
+
+	sb.WriteString("Statements:\n")
+	for _, statement := range t.Statements {
+		indent := strings.Repeat("  ", statement.Depth+1)
+		sb.WriteString(fmt.Sprintf("%sLine %s: %s", indent, lines.Location(statement.Query.LineNumber), strings.Join(strings.Fields(statement.Query.Text), " ")))
+		if statement.Query.Error != "" {
+			sb.WriteString(fmt.Sprintf(" (error: %s)", statement.Query.Error))
+		}
+		sb.WriteString("\n")
+	}
+
+	return sb.String()
+}
+
+type TransactionAnomalyKind int
+
+const (
+	RollbackToUnknownSavepoint TransactionAnomalyKind = iota
+	ReleaseOfReleasedSavepoint
+	ReleaseOfUnknownSavepoint
+	TransactionOpenAtConnectionClose
+	CommitWithAutocommitOn
+)
+
+func (k TransactionAnomalyKind) String() string {
+	switch k {
+	case RollbackToUnknownSavepoint:
+		return "rollback to unknown savepoint"
+	case ReleaseOfReleasedSavepoint:
+		return "release of already released savepoint"
+	case ReleaseOfUnknownSavepoint:
+		return "release of unknown savepoint"
+	case TransactionOpenAtConnectionClose:
+		return "transaction open when connection closed"
+	case CommitWithAutocommitOn:
+		return "commit with autocommit on"
+	default:
+		return fmt.Sprintf("unknown anomaly %d", int(k))
+	}
+}
+
+type TransactionAnomaly struct {
+	Kind         TransactionAnomalyKind
+	LineNumber   int
+	ConnectionId int
+	// TransactionId is zero if the statement didn't run in a transaction
+	TransactionId int
+	Savepoint     string
+}
+
+func (a *TransactionAnomaly) String(lines *LogLines) string {
+	sb := strings.Builder{}
+	sb.WriteString(fmt.Sprintf("Line %s, connection %d: %s", lines.Location(a.LineNumber), a.ConnectionId, a.Kind))
+	if a.Savepoint != "" {
+		sb.WriteString(fmt.Sprintf(" %s", a.Savepoint))
+	}
+	if a.TransactionId != 0 {
+		sb.WriteString(fmt.Sprintf(" (transaction %d)", a.TransactionId))
+	}
+	return sb.String()
+}
+
+type connectionTransactions struct {
+	current *Transaction
+	// active is the stack of savepoints that can still be rolled back to, innermost last
+	active []*Savepoint
+}
+
+// transactionTracker reconstructs the transactions and savepoints of every connection from the statements it ran.
+type transactionTracker struct {
+	connections  map[int]*connectionTransactions
+	transactions []*Transaction
+	anomalies    []TransactionAnomaly
+}
+
+func newTransactionTracker() *transactionTracker {
+	return &transactionTracker{
+		connections:  make(map[int]*connectionTransactions),
+		transactions: make([]*Transaction, 0),
+		anomalies:    make([]TransactionAnomaly, 0),
+	}
+}
+
+// process replays a statement into the transaction state of its connection and returns the id of the transaction
+// the statement ran in, or zero if it ran outside of one, along with the number of savepoints active at the time.
+// before and after are the session states before and after the statement ran. Statements that failed don't change
+// the transaction state, but failed savepoint statements are still checked for anomalies.
+func (t *transactionTracker) process(record LogRecord, node sql.Node, queryError string, before, after *SessionState) (int, int) {
+	state, ok := t.connections[record.ConnectionId]
+	if !ok {
+		state = &connectionTransactions{}
+		t.connections[record.ConnectionId] = state
+	}
+	lineNumber := record.LineNumber
+	succeeded := queryError == ""
+
+	switch node := node.(type) {
+	case *plan.StartTransaction:
+		if !succeeded {
+			return t.currentId(state), len(state.active)
+		}
+		t.finish(state, TransactionImplicitlyCommitted, lineNumber)
+		t.begin(state, record, true)
+		return state.current.Id, 0
+	case *plan.Commit, *plan.Rollback:
+		if state.current == nil {
+			if _, isCommit := node.(*plan.Commit); isCommit && before.Autocommit() {
+				t.flag(CommitWithAutocommitOn, record, 0, "")
+			}
+			return 0, 0
+		}
+		id := state.current.Id
+		if succeeded {
+			outcome := TransactionCommitted
+			if _, isRollback := node.(*plan.Rollback); isRollback {
+				outcome = TransactionRolledBack
+			}
+			t.finish(state, outcome, lineNumber)
+		}
+		return id, 0
+	case *plan.CreateSavepoint:
+		if succeeded {
+			t.ensureStarted(state, record, before)
+		}
+		depth := len(state.active)
+		if succeeded && state.current != nil {
+			name := savepointName(node)
+			t.removeSavepoint(state, name, lineNumber)
+			savepoint := &Savepoint{Name: name, CreatedLineNumber: lineNumber}
+			if len(state.active) > 0 {
+				parent := state.active[len(state.active)-1]
+				parent.Children = append(parent.Children, savepoint)
+			} else {
+				state.current.Savepoints = append(state.current.Savepoints, savepoint)
+			}
+			state.active = append(state.active, savepoint)
+		}
+		return t.currentId(state), depth
+	case *plan.RollbackSavepoint:
+		name := savepointName(node)
+		index := findSavepoint(state.active, name)
+		if index < 0 {
+			t.flag(RollbackToUnknownSavepoint, record, t.currentId(state), name)
+			return t.currentId(state), len(state.active)
+		}
+		if succeeded {
+			savepoint := state.active[index]
+			savepoint.RollbackLineNumbers = append(savepoint.RollbackLineNumbers, lineNumber)
+			t.releaseFrom(state, index+1, lineNumber)
+		}
+		return t.currentId(state), index + 1
+	case *plan.ReleaseSavepoint:
+		name := savepointName(node)
+		index := findSavepoint(state.active, name)
+		if index < 0 {
+			kind := ReleaseOfUnknownSavepoint
+			if state.current != nil && findReleasedSavepoint(state.current.Savepoints, name) {
+				kind = ReleaseOfReleasedSavepoint
+			}
+			t.flag(kind, record, t.currentId(state), name)
+			return t.currentId(state), len(state.active)
+		}
+		if succeeded {
+			t.releaseFrom(state, index, lineNumber)
+		}
+		return t.currentId(state), index
+	}
+
+	if !succeeded {
+		return t.currentId(state), len(state.active)
+	}
+
+	if causesImplicitCommit(node) {
+		if state.current != nil {
+			t.finish(state, TransactionImplicitlyCommitted, lineNumber)
+		}
+		return 0, 0
+	}
+
+	if _, isSet := node.(*plan.Set); isSet {
+		// turning autocommit on commits the open transaction
+		if state.current != nil && !before.Autocommit() && after.Autocommit() {
+			id := state.current.Id
+			t.finish(state, TransactionImplicitlyCommitted, lineNumber)
+			return id, 0
+		}
+		return t.currentId(state), len(state.active)
+	}
+	if _, isUse := node.(*plan.Use); isUse {
+		return t.currentId(state), len(state.active)
+	}
+
+	t.ensureStarted(state, record, before)
+	return t.currentId(state), len(state.active)
+}
+
+// addQuery attaches a query to the transaction it ran in.
+func (t *transactionTracker) addQuery(query Query, depth int) {
+	if query.TransactionId == 0 {
+		return
+	}
+	transaction := t.transactions[query.TransactionId-1]
+	transaction.Statements = append(transaction.Statements, TransactionStatement{Query: query, Depth: depth})
+}
+
+func (t *transactionTracker) connectionClosed(record LogRecord) {
+	state, ok := t.connections[record.ConnectionId]
+	if !ok {
+		return
+	}
+	if state.current != nil {
+		t.flag(TransactionOpenAtConnectionClose, record, state.current.Id, "")
+		t.finish(state, TransactionAbandoned, record.LineNumber)
+	}
+	delete(t.connections, record.ConnectionId)
+}
+
+// end returns all transactions in the order they started, and all anomalies in log order.
+func (t *transactionTracker) end() ([]Transaction, []TransactionAnomaly) {
+	transactions := make([]Transaction, len(t.transactions))
+	for i, transaction := range t.transactions {
+		transactions[i] = *transaction
+	}
+	sort.SliceStable(t.anomalies, func(i, j int) bool {
+		return t.anomalies[i].LineNumber < t.anomalies[j].LineNumber
+	})
+	return transactions, t.anomalies
+}
+
+func (t *transactionTracker) currentId(state *connectionTransactions) int {
+	if state.current == nil {
+		return 0
+	}
+	return state.current.Id
+}
+
+// ensureStarted starts a transaction if there isn't one and autocommit is off.
+func (t *transactionTracker) ensureStarted(state *connectionTransactions, record LogRecord, session *SessionState) {
+	if state.current == nil && !session.Autocommit() {
+		t.begin(state, record, false)
+	}
+}
+
+func (t *transactionTracker) begin(state *connectionTransactions, record LogRecord, explicit bool) {
+	transaction := &Transaction{
+		Id:              len(t.transactions) + 1,
+		ConnectionId:    record.ConnectionId,
+		Explicit:        explicit,
+		StartLineNumber: record.LineNumber,
+		Outcome:         TransactionOpen,
+	}
+	t.transactions = append(t.transactions, transaction)
+	state.current = transaction
+	state.active = nil
+}
+
+func (t *transactionTracker) finish(state *connectionTransactions, outcome TransactionOutcome, lineNumber int) {
+	if state.current == nil {
+		return
+	}
+	t.releaseFrom(state, 0, lineNumber)
+	state.current.Outcome = outcome
+	state.current.EndLineNumber = lineNumber
+	state.current = nil
+}
+
+// releaseFrom releases the active savepoints starting at the given index.
+func (t *transactionTracker) releaseFrom(state *connectionTransactions, index int, lineNumber int) {
+	for _, savepoint := range state.active[index:] {
+		savepoint.ReleasedLineNumber = lineNumber
+	}
+	state.active = state.active[:index]
+}
+
+// removeSavepoint drops an active savepoint that is being replaced by a new one with the same name.
+func (t *transactionTracker) removeSavepoint(state *connectionTransactions, name string, lineNumber int) {
+	index := findSavepoint(state.active, name)
+	if index < 0 {
+		return
+	}
+	state.active[index].ReleasedLineNumber = lineNumber
+	state.active = append(state.active[:index], state.active[index+1:]...)
+}
+
+func (t *transactionTracker) flag(kind TransactionAnomalyKind, record LogRecord, transactionId int, savepoint string) {
+	t.anomalies = append(t.anomalies, TransactionAnomaly{
+		Kind:          kind,
+		LineNumber:    record.LineNumber,
+		ConnectionId:  record.ConnectionId,
+		TransactionId: transactionId,
+		Savepoint:     savepoint,
+	})
+}
+
+func savepointName(node sql.Node) string {
+	var name string
+	switch node := node.(type) {
+	case *plan.CreateSavepoint:
+		name = strings.TrimPrefix(node.String(), "SAVEPOINT ")
+	case *plan.RollbackSavepoint:
+		name = strings.TrimPrefix(node.String(), "ROLLBACK TO SAVEPOINT ")
+	case *plan.ReleaseSavepoint:
+		name = strings.TrimPrefix(node.String(), "RELEASE SAVEPOINT ")
+	}
+	return strings.ToLower(name)
+}
+
+// findSavepoint returns the index of the innermost active savepoint with the given name, or -1.
+func findSavepoint(active []*Savepoint, name string) int {
+	for i := len(active) - 1; i >= 0; i-- {
+		if active[i].Name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+func findReleasedSavepoint(savepoints []*Savepoint, name string) bool {
+	for _, savepoint := range savepoints {
+		if savepoint.Name == name && savepoint.ReleasedLineNumber != 0 {
+			return true
+		}
+		if findReleasedSavepoint(savepoint.Children, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// causesImplicitCommit returns whether the statement commits the open transaction before it runs, like DDL does.
+func causesImplicitCommit(node sql.Node) bool {
+	switch node := node.(type) {
+	case *plan.CreateTable:
+		return node.Temporary() != plan.IsTempTable
+	case *plan.Block:
+		for _, child := range node.Children() {
+			if causesImplicitCommit(child) {
+				return true
+			}
+		}
+		return false
+	case *plan.DropTable, *plan.Truncate, *plan.RenameTable,
+		*plan.AddColumn, *plan.DropColumn, *plan.ModifyColumn, *plan.RenameColumn,
+		*plan.AlterPK, *plan.AlterIndex, *plan.AlterAutoIncrement, *plan.AlterDefaultSet, *plan.AlterDefaultDrop,
+		*plan.CreateIndex, *plan.DropIndex, *plan.CreateForeignKey, *plan.DropForeignKey,
+		*plan.CreateCheck, *plan.DropCheck, *plan.DropConstraint,
+		*plan.CreateDB, *plan.DropDB, *plan.AlterDB,
+		*plan.CreateView, *plan.DropView, *plan.CreateTrigger, *plan.DropTrigger,
+		*plan.CreateProcedure, *plan.DropProcedure,
+		*plan.CreateUser, *plan.DropUser, *plan.RenameUser, *plan.CreateRole, *plan.DropRole,
+		*plan.LockTables, *plan.UnlockTables:
+		return true
+	default:
+		return false
+	}
+}
@@ -7,28 +7,163 @@ import (
 	"github.com/dolthub/go-mysql-server/sql/plan"
 	"github.com/dolthub/go-mysql-server/sql/transform"
 	"github.com/dolthub/go-mysql-server/sql/types"
+	"strings"
+	"time"
 )
 
 var stringPlaceholder = "placeholder"
 
-func getPlaceholder(dataType sql.Type) sql.Expression {
-	switch dataType.(type) {
+// timePlaceholder is the value of every date, datetime and timestamp literal once normalized
+var timePlaceholder = time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// getPlaceholder returns the literal that replaces literals of the given type. Literals keep their type,
+// so that queries only differing by their values normalize to the same tree.
+func getPlaceholder(dataType sql.Type) (sql.Expression, error) {
+	// sql.NullType and sql.YearType are satisfied by every type, so those are checked for by their concrete type
+	if dataType == types.Null {
+		return expression.NewLiteral(nil, types.Null), nil
+	}
+	switch dataType := dataType.(type) {
 	case sql.StringType:
-		return expression.NewLiteral(stringPlaceholder, types.Text)
+		if types.IsBinaryType(dataType) {
+			return expression.NewLiteral([]byte(stringPlaceholder), types.LongBlob), nil
+		}
+		return expression.NewLiteral(stringPlaceholder, types.Text), nil
 	case sql.NumberType:
-		return expression.NewLiteral(1, types.Int64)
+		return expression.NewLiteral(1, types.Int64), nil
 	case sql.DecimalType:
-		return expression.NewLiteral(1, types.Int64)
-	case sql.NullType:
-		return expression.NewLiteral(nil, types.Null)
+		return expression.NewLiteral(1, types.Int64), nil
+	case types.JsonType:
+		return expression.NewLiteral(types.JSONDocument{Val: map[string]interface{}{}}, types.JSON), nil
+	case sql.DatetimeType:
+		return expression.NewLiteral(timePlaceholder, dataType), nil
+	case types.TimeType:
+		return expression.NewLiteral(types.Timespan(0), types.Time), nil
+	case types.YearType_:
+		return expression.NewLiteral(int16(timePlaceholder.Year()), types.Year), nil
+	case types.BitType:
+		return expression.NewLiteral(uint64(0), dataType), nil
+	case sql.EnumType:
+		return expression.NewLiteral(uint16(1), dataType), nil
+	case sql.SetType:
+		return expression.NewLiteral(uint64(0), dataType), nil
+	case sql.SpatialColumnType:
+		return expression.NewLiteral(types.Point{}, types.PointType{}), nil
+	case sql.DeferredType:
+		// deferred literals stand in for bind variables, they're placeholders already
+		return nil, nil
+	case types.TupleType:
+		values := make([]interface{}, len(dataType))
+		for i, elementType := range dataType {
+			placeholder, err := getPlaceholder(elementType)
+			if err != nil {
+				return nil, err
+			}
+			if placeholder == nil {
+				return nil, nil
+			}
+			literal, ok := placeholder.(*expression.Literal)
+			if !ok {
+				return nil, fmt.Errorf("unhandled tuple element placeholder: %T", placeholder)
+			}
+			values[i] = literal.Value()
+		}
+		return expression.NewLiteral(values, dataType), nil
 	default:
-		panic(fmt.Sprintf("unhandled type: %s", dataType))
+		return nil, fmt.Errorf("unhandled literal type: %s (%T)", dataType, dataType)
 	}
 }
 
+// DropExtraneousData normalizes a parsed query so that queries that only differ by their literals, table names,
+// aliases, savepoint names, CTE names or prepared statement names produce the same tree.
+// It returns an error when it runs into something it can't normalize.
 func DropExtraneousData(node sql.Node) (sql.Node, error) {
+	if node == nil {
+		return nil, fmt.Errorf("normalizing a query without a tree")
+	}
+	return newTreeNormalizer().node(node)
+}
+
+// debugString returns the debug string of the tree. EXECUTE panics when it's printed, so it's printed by hand.
+func debugString(node sql.Node) string {
+	execute, ok := node.(*plan.ExecuteQuery)
+	if !ok {
+		return sql.DebugString(node)
+	}
+	bindVars := make([]string, len(execute.BindVars))
+	for i, bindVar := range execute.BindVars {
+		bindVars[i] = sql.DebugString(bindVar)
+	}
+	return fmt.Sprintf("Execute(%s)\n └─ Using(%s)\n", execute.Name, strings.Join(bindVars, ", "))
+}
+
+// treeNormalizer renames aliases in order of appearance, so that references to an alias are renamed
+// the same way as the alias itself, including from subqueries.
+type treeNormalizer struct {
+	tableAliases  map[string]string
+	columnAliases map[string]string
+}
+
+func newTreeNormalizer() *treeNormalizer {
+	return &treeNormalizer{
+		tableAliases:  make(map[string]string),
+		columnAliases: make(map[string]string),
+	}
+}
+
+func (n *treeNormalizer) tableAlias(name string) string {
+	return aliasPlaceholder(n.tableAliases, name, "t")
+}
+
+func (n *treeNormalizer) columnAlias(name string) string {
+	return aliasPlaceholder(n.columnAliases, name, "c")
+}
+
+func aliasPlaceholder(aliases map[string]string, name string, prefix string) string {
+	name = strings.ToLower(name)
+	alias, ok := aliases[name]
+	if !ok {
+		alias = fmt.Sprintf("%s%d", prefix, len(aliases)+1)
+		aliases[name] = alias
+	}
+	return alias
+}
+
+func (n *treeNormalizer) node(node sql.Node) (sql.Node, error) {
+	// EXECUTE panics on everything but its fields, so it can't go through the transform
+	if execute, ok := node.(*plan.ExecuteQuery); ok {
+		bindVars := make([]sql.Expression, len(execute.BindVars))
+		for i, bindVar := range execute.BindVars {
+			if bindVar == nil {
+				return nil, fmt.Errorf("normalizing EXECUTE %s: missing bind variable %d", execute.Name, i+1)
+			}
+			newBindVar, _, err := n.expression(bindVar)
+			if err != nil {
+				return nil, err
+			}
+			bindVars[i] = newBindVar
+		}
+		return plan.NewExecuteQuery(stringPlaceholder, bindVars...), nil
+	}
+
+	// aliases are named before anything is renamed, since references can be visited before the alias they refer to
+	transform.Inspect(node, func(node sql.Node) bool {
+		switch node := node.(type) {
+		case *plan.TableAlias:
+			n.tableAlias(node.Name())
+		case *plan.SubqueryAlias:
+			n.tableAlias(node.Name())
+		}
+		return true
+	})
+	transform.InspectExpressions(node, func(e sql.Expression) bool {
+		if alias, ok := e.(*expression.Alias); ok {
+			n.columnAlias(alias.Name())
+		}
+		return true
+	})
+
 	newNode, _, err := transform.NodeWithOpaque(node, func(node sql.Node) (sql.Node, transform.TreeIdentity, error) {
-		var err error
 		var newNode sql.Node
 		switch node := node.(type) {
 		case *plan.CreateSavepoint:
@@ -40,12 +175,51 @@ func DropExtraneousData(node sql.Node) (sql.Node, error) {
 		case *plan.UnresolvedTable:
 			newNode = plan.NewResolvedDualTable()
 		case *plan.TableAlias:
-			newNode = plan.NewTableAlias(stringPlaceholder, node.Child)
+			newNode = plan.NewTableAlias(n.tableAlias(node.Name()), node.Child)
+		case *plan.SubqueryAlias:
+			newNode = node.WithName(n.tableAlias(node.Name()))
+			newNode.(*plan.SubqueryAlias).TextDefinition = ""
 		case *plan.Project:
 			newNode = plan.NewProject([]sql.Expression{expression.NewStar()}, node.Child)
-		default:
-			//fmt.Printf("unhandled node type: %T", node)
-			//panic(fmt.Sprintf("unhandled node type: %T", node))
+		case *plan.With:
+			ctes := make([]*plan.CommonTableExpression, len(node.CTEs))
+			for i, cte := range node.CTEs {
+				if cte == nil || cte.Subquery == nil {
+					return nil, transform.SameTree, fmt.Errorf("normalizing WITH: CTE %d has no subquery", i+1)
+				}
+				subquery, err := n.node(cte.Subquery)
+				if err != nil {
+					return nil, transform.SameTree, err
+				}
+				subqueryAlias, ok := subquery.(*plan.SubqueryAlias)
+				if !ok {
+					return nil, transform.SameTree, fmt.Errorf("normalizing WITH: CTE %d normalized to %T", i+1, subquery)
+				}
+				ctes[i] = plan.NewCommonTableExpression(subqueryAlias, cte.Columns)
+			}
+			newNode = plan.NewWith(node.Child, ctes, node.Recursive)
+		case *plan.InsertInto:
+			// neither the inserted rows nor the prepared statement are children of their node,
+			// so the transform doesn't reach them
+			if node.Source == nil {
+				break
+			}
+			source, err := n.node(node.Source)
+			if err != nil {
+				return nil, transform.SameTree, err
+			}
+			newNode = node.WithSource(source)
+		case *plan.PrepareQuery:
+			if node.Child == nil {
+				return nil, transform.SameTree, fmt.Errorf("normalizing PREPARE %s: no statement", node.Name)
+			}
+			child, err := n.node(node.Child)
+			if err != nil {
+				return nil, transform.SameTree, err
+			}
+			newNode = plan.NewPrepareQuery(stringPlaceholder, child)
+		case *plan.DeallocateQuery:
+			newNode = plan.NewDeallocateQuery(stringPlaceholder)
 		}
 
 		sameAll := transform.SameTree
@@ -55,25 +229,8 @@ func DropExtraneousData(node sql.Node) (sql.Node, error) {
 			newNode = node
 		}
 
-		newNode, same, err := transform.NodeExprs(newNode, func(e sql.Expression) (sql.Expression, transform.TreeIdentity, error) {
-			var newExpr sql.Expression
-			switch e := e.(type) {
-			case *plan.Subquery:
-				query := e.Query
-				newQuery, err := DropExtraneousData(query)
-				if err != nil {
-					return nil, transform.SameTree, err
-				}
-				newExpr = e.WithQuery(newQuery)
-			case *expression.Literal:
-				newExpr = getPlaceholder(e.Type())
-			default:
-				return e, transform.SameTree, nil
-			}
-			if newExpr == nil {
-				panic("newExpr is nil")
-			}
-			return newExpr, transform.NewTree, nil
+		newNode, same, err := transform.OneNodeExprsWithNode(newNode, func(_ sql.Node, e sql.Expression) (sql.Expression, transform.TreeIdentity, error) {
+			return n.expression(e)
 		})
 		if err != nil {
 			return nil, transform.SameTree, err
@@ -84,3 +241,44 @@ func DropExtraneousData(node sql.Node) (sql.Node, error) {
 	})
 	return newNode, err
 }
+
+func (n *treeNormalizer) expression(e sql.Expression) (sql.Expression, transform.TreeIdentity, error) {
+	return transform.Expr(e, func(e sql.Expression) (sql.Expression, transform.TreeIdentity, error) {
+		var newExpr sql.Expression
+		switch e := e.(type) {
+		case *plan.Subquery:
+			newQuery, err := n.node(e.Query)
+			if err != nil {
+				return nil, transform.SameTree, err
+			}
+			subquery := e.WithQuery(newQuery)
+			subquery.QueryString = ""
+			newExpr = subquery
+		case *expression.Literal:
+			placeholder, err := getPlaceholder(e.Type())
+			if err != nil {
+				return nil, transform.SameTree, fmt.Errorf("normalizing literal %s: %w", e, err)
+			}
+			if placeholder == nil {
+				return e, transform.SameTree, nil
+			}
+			newExpr = placeholder
+		case *expression.Alias:
+			newExpr = expression.NewAlias(n.columnAlias(e.Name()), e.Child)
+		case *expression.AliasReference:
+			newExpr = expression.NewAliasReference(n.columnAlias(e.Name()))
+		case *expression.UnresolvedColumn:
+			if e.Table() != "" {
+				if alias, ok := n.tableAliases[strings.ToLower(e.Table())]; ok {
+					newExpr = expression.NewUnresolvedQualifiedColumn(alias, e.Name())
+				}
+			} else if alias, ok := n.columnAliases[strings.ToLower(e.Name())]; ok {
+				newExpr = expression.NewUnresolvedColumn(alias)
+			}
+		}
+		if newExpr == nil {
+			return e, transform.SameTree, nil
+		}
+		return newExpr, transform.NewTree, nil
+	})
+}